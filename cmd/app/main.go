@@ -22,6 +22,13 @@ func init() {
 	utils.InitializeTurboSMTP()
 	initializer.InitiailizeGoogle()
 	initializer.R2Init()
+	utils.InitRealtime()
+
+	// Leaving a page commits the saves the user has waiting in the queue
+	utils.OnProjectLeave = controller.FlushUserCommits
+
+	// Pages synced from a push publish the drawings they embed
+	utils.EmbeddedDrawings = controller.SyncedDrawings
 }
 
 func main() {
@@ -37,6 +44,21 @@ func main() {
 	// every 10 min clear the expired token
 	scheduler.Every(10).Minutes().Do(controller.CleanupExpiredOTPs)
 
+	// every 30 min check the editing branches against GitHub
+	scheduler.Every(30).Minutes().Do(controller.ReconcileEditingBranches)
+
+	// every 30 sec drop presence of connections that went stale
+	scheduler.Every(30).Seconds().Do(utils.ExpireStalePresence)
+
+	// every monday save last week's changelog of the projects that want it
+	scheduler.Every(1).Monday().At("08:00").Do(controller.WeeklyChangelogs)
+
+	// every 10 sec commit the queued saves whose batch window is over
+	scheduler.Every(10).Seconds().Do(controller.FlushDueCommits)
+
+	// every 20 sec refresh the presence other instances hold for our clients
+	scheduler.Every(20).Seconds().Do(utils.PublishPresenceSync)
+
 	// Start the scheduler in blocking mode
 	scheduler.StartAsync()
 
@@ -90,6 +112,24 @@ func main() {
 	// api route for Create delete branch
 	api.BranchRoutes(router.Group(baseRoute + "/branch"))
 
+	// api routes for autosaved drafts
+	api.DraftRoutes(router.Group(baseRoute + "/draft"))
+
+	// api routes for reviewing editing branches
+	api.ReviewRoutes(router.Group(baseRoute + "/review"))
+
+	// api routes for the docs changelog
+	api.ChangelogRoutes(router.Group(baseRoute + "/changelog"))
+
+	// api routes for versioned docs releases
+	api.ReleaseRoutes(router.Group(baseRoute + "/release"))
+
+	// api routes for the GitHub webhook delivery log
+	api.WebhookRoutes(router.Group(baseRoute + "/webhook"))
+
+	// api routes for publish jobs
+	api.PublishRoutes(router.Group(baseRoute + "/publish"))
+
 	// api routes for public facing documentations
 	api.PublicRoutes(router.Group(baseRoute + "/public"))
 
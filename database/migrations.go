@@ -135,6 +135,335 @@ func Migrations() {
 		log.Fatalf("Failed to execute migration: %v", err)
 	}
 
+	// Last sequence number handed out to realtime events of each project
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS realtime_sequences (
+        project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+        seq BIGINT NOT NULL DEFAULT 0
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Bounded log of recent realtime events so reconnecting clients can catch up
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS realtime_events (
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        seq BIGINT NOT NULL,
+        payload TEXT NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT now(),
+        PRIMARY KEY (project_id, seq)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Autosaved working copies of pages that are not committed yet
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS drafts (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        updated_at TIMESTAMPTZ DEFAULT now(),
+        user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        path TEXT NOT NULL,
+        page_id TEXT NOT NULL DEFAULT '',
+        name TEXT NOT NULL DEFAULT '',
+        type TEXT NOT NULL DEFAULT '',
+        original_content TEXT NOT NULL DEFAULT '',
+        changed_content TEXT NOT NULL,
+        CONSTRAINT draft_unique UNIQUE (user_id, project_id, path)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Editing branches created from the app and what happened to them
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS editing_branches (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        updated_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        branch_name TEXT NOT NULL,
+        base_sha TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'active',
+        CONSTRAINT editing_branch_unique UNIQUE (project_id, branch_name)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// The branch the user is currently editing on, a user can have several
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE editing_branches ADD COLUMN IF NOT EXISTS is_current BOOLEAN NOT NULL DEFAULT FALSE`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Number of Admin approvals a branch needs before it can be merged, 0 disables reviews
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE projects ADD COLUMN IF NOT EXISTS required_approvals INT NOT NULL DEFAULT 0`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Review requests for editing branches, pr_number is set when a GitHub PR backs it
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS review_requests (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        updated_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        author_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        branch_name TEXT NOT NULL,
+        title TEXT NOT NULL DEFAULT '',
+        description TEXT NOT NULL DEFAULT '',
+        pr_number INT,
+        pr_url TEXT,
+        status TEXT NOT NULL DEFAULT 'open'
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Reviewers assigned to a review request and their decision
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS review_reviewers (
+        review_id UUID NOT NULL REFERENCES review_requests(id) ON DELETE CASCADE,
+        user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        decision TEXT NOT NULL DEFAULT 'pending',
+        comment TEXT NOT NULL DEFAULT '',
+        decided_at TIMESTAMPTZ,
+        PRIMARY KEY (review_id, user_id)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Seconds saves are collected into one commit, 0 commits every save right away
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE projects ADD COLUMN IF NOT EXISTS commit_batch_window INT NOT NULL DEFAULT 0`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Who made each commit, GitHub only sees the token that was used
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS commit_activity (
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        sha TEXT NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT now(),
+        user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        branch_name TEXT NOT NULL,
+        message TEXT NOT NULL DEFAULT '',
+        co_authors UUID[] NOT NULL DEFAULT '{}',
+        PRIMARY KEY (project_id, sha)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `CREATE INDEX IF NOT EXISTS commit_activity_project_created_idx ON commit_activity (project_id, created_at DESC)`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Slug the docs are published under, public versions are looked up by it
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE projects ADD COLUMN IF NOT EXISTS published_docs_name TEXT`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Docs releases cut from main as a tag or a release branch
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS releases (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        version TEXT NOT NULL,
+        kind TEXT NOT NULL DEFAULT 'tag',
+        ref TEXT NOT NULL,
+        sha TEXT NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+        published_at TIMESTAMPTZ,
+        UNIQUE (project_id, version)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Opt in to the weekly changelog job
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE projects ADD COLUMN IF NOT EXISTS weekly_changelog BOOLEAN NOT NULL DEFAULT false`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Changelogs saved by the weekly job
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS changelogs (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        since TIMESTAMPTZ NOT NULL,
+        until TIMESTAMPTZ NOT NULL,
+        markdown TEXT NOT NULL,
+        data JSONB NOT NULL
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// GitHub webhook deliveries, kept to skip duplicates and replay failures
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+        delivery_id TEXT PRIMARY KEY,
+        created_at TIMESTAMPTZ DEFAULT now(),
+        updated_at TIMESTAMPTZ DEFAULT now(),
+        event TEXT NOT NULL,
+        project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+        payload BYTEA NOT NULL,
+        status TEXT NOT NULL DEFAULT 'processing',
+        error TEXT NOT NULL DEFAULT '',
+        attempts INT NOT NULL DEFAULT 1
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `CREATE INDEX IF NOT EXISTS webhook_deliveries_project_status_idx ON webhook_deliveries (project_id, status, created_at DESC)`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Publish jobs and their progress
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS publish_jobs (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        kind TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'queued',
+        total_files INT NOT NULL DEFAULT 0,
+        uploaded_files INT NOT NULL DEFAULT 0,
+        error TEXT NOT NULL DEFAULT '',
+        created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+        started_at TIMESTAMPTZ,
+        finished_at TIMESTAMPTZ
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Deploys of the public docs, readers follow the current one
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS publish_deploys (
+        id UUID PRIMARY KEY,
+        created_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        slug TEXT NOT NULL,
+        parent_id UUID,
+        job_id UUID REFERENCES publish_jobs(id) ON DELETE SET NULL,
+        created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+        file_count INT NOT NULL DEFAULT 0,
+        uploaded_count INT NOT NULL DEFAULT 0,
+        pruned BOOLEAN NOT NULL DEFAULT false
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE projects ADD COLUMN IF NOT EXISTS current_deploy_id UUID`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Previews of editing branches, served under a token until the branch is merged or deleted
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS previews (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+        created_at TIMESTAMPTZ DEFAULT now(),
+        updated_at TIMESTAMPTZ DEFAULT now(),
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        branch_name TEXT NOT NULL,
+        slug TEXT NOT NULL,
+        token TEXT NOT NULL UNIQUE,
+        job_id UUID REFERENCES publish_jobs(id) ON DELETE SET NULL,
+        created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+        expired_at TIMESTAMPTZ
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// One live preview per branch
+	_, err = initializer.DB.Exec(context.Background(), `CREATE UNIQUE INDEX IF NOT EXISTS previews_live_branch_idx ON previews (project_id, branch_name) WHERE expired_at IS NULL`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Head of the branch a review decision was made on, approvals only count for it
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE review_reviewers ADD COLUMN IF NOT EXISTS head_sha TEXT`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Saves waiting for the commit batch window of their project, shared by every instance
+	_, err = initializer.DB.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS commit_queue (
+        project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+        branch_name TEXT NOT NULL,
+        user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        user_name TEXT NOT NULL DEFAULT '',
+        project_name TEXT NOT NULL,
+        org TEXT NOT NULL DEFAULT '',
+        contents JSONB NOT NULL DEFAULT '{}',
+        paths TEXT[] NOT NULL DEFAULT '{}',
+        messages TEXT[] NOT NULL DEFAULT '{}',
+        users TEXT[] NOT NULL DEFAULT '{}',
+        flush_at TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (project_id, branch_name)
+    )`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// One weekly changelog per project and day, every instance runs the weekly job
+	_, err = initializer.DB.Exec(context.Background(), `DELETE FROM changelogs a USING changelogs b
+        WHERE a.project_id = b.project_id
+        AND (a.until AT TIME ZONE 'UTC')::date = (b.until AT TIME ZONE 'UTC')::date
+        AND a.id > b.id`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `CREATE UNIQUE INDEX IF NOT EXISTS changelogs_project_day
+        ON changelogs (project_id, ((until AT TIME ZONE 'UTC')::date))`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
+	// Failed commits of a queued batch, it stops being tried once failed_at is set
+	_, err = initializer.DB.Exec(context.Background(), `ALTER TABLE commit_queue
+        ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+        ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '',
+        ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ`)
+
+	if err != nil {
+		log.Fatalf("Failed to execute migration: %v", err)
+	}
+
 	log.Println("All migrations executed successfully")
 
 }
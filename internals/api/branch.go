@@ -17,4 +17,22 @@ func BranchRoutes(router *gin.RouterGroup) {
 
 	router.GET("/check/:id", controller.CheckIfEditingBranchExists)
 
+	// GET api to list the editing branches of a project
+	router.GET("/list/:id", controller.ListBranches)
+
+	// PUT api to switch the branch the user edits on
+	router.PUT("/switch", controller.SwitchBranch)
+
+	// PATCH api to rename a branch
+	router.PATCH("/rename", controller.RenameBranch)
+
+	// GET api to compare a branch (?branch=) with main
+	router.GET("/compare/:id", controller.CompareBranch)
+
+	// POST api to merge a branch into main without a pull request
+	router.POST("/merge", controller.MergeBranch)
+
+	// POST api to rebase a branch onto the latest main
+	router.POST("/rebase", controller.RebaseBranch)
+
 }
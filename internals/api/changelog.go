@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+)
+
+func ChangelogRoutes(router *gin.RouterGroup) {
+	router.Use(middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor, models.RoleViever}))
+
+	// GET api to build the changelog of main, ?since= ?until= ?format=markdown
+	router.GET("/:id", controller.GetChangelog)
+
+	// GET api to list the changelogs saved by the weekly job
+	router.GET("/history/:id", controller.ListChangelogs)
+
+	// PUT api to turn the weekly changelog on or off
+	router.PUT("/weekly", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.SetWeeklyChangelog)
+}
@@ -16,4 +16,16 @@ func CommitRoutes(router *gin.RouterGroup) {
 
 	router.POST("/drawings", controller.SaveDrawings)
 
+	// GET api to list the saves waiting in the commit queue
+	router.GET("/queue/:id", controller.GetQueuedCommits)
+
+	// POST api to commit the queued saves right away
+	router.POST("/flush/:id", controller.FlushCommits)
+
+	// GET api to list who made the latest commits of a project
+	router.GET("/activity/:id", controller.GetCommitActivity)
+
+	// PUT api to set the commit batch window of a project
+	router.PUT("/batch-window", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.SetCommitBatchWindow)
+
 }
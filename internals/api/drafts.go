@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+)
+
+func DraftRoutes(router *gin.RouterGroup) {
+	router.Use(middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}))
+
+	// PUT api to autosave the working copy of a page
+	router.PUT("", controller.SaveDraft)
+
+	// GET api to list the drafts of the user for a project
+	router.GET("/:id", controller.GetDrafts)
+
+	// DELETE api to discard a draft, the path is passed as ?path=
+	router.DELETE("/:id", controller.DiscardDraft)
+
+	// POST api to commit the drafts in bulk and clear them
+	router.POST("/commit", controller.CommitDrafts)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
 	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
 	"github.com/gin-gonic/gin"
 )
 
@@ -13,12 +14,36 @@ func FilesRoutes(router *gin.RouterGroup) {
 	// GET api to get file contents
 	router.GET("/get", controller.GetFileContents)
 
+	// GET api to resolve the drawings embedded in a file
+	router.GET("/embeds", controller.GetEmbeddedDrawings)
+
 	// GET api to get drawings
 	router.GET("/drawings", controller.GetDrawings)
 
 	// Get api to get the drawings of specific drawing
 	router.GET("/drawings/:name", controller.GetSpecificDrawing)
 
+	// GET api to render a drawing as svg or png (?format=png)
+	router.GET("/drawings/:name/render", controller.RenderDrawing)
+
+	// GET api to list the commits that changed a drawing
+	router.GET("/drawings/:name/history", controller.GetDrawingHistory)
+
+	// GET api to get a drawing as it was at a commit
+	router.GET("/drawings/:name/version/:sha", controller.GetDrawingVersion)
+
+	// POST api to rename a drawing
+	router.POST("/drawings/rename", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}), controller.RenameDrawing)
+
+	// POST api to duplicate a drawing
+	router.POST("/drawings/duplicate", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}), controller.DuplicateDrawing)
+
+	// POST api to restore a drawing to a version from its history
+	router.POST("/drawings/restore", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}), controller.RestoreDrawing)
+
+	// DELETE api to delete a drawing
+	router.DELETE("/drawings/:name", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}), controller.DeleteDrawing)
+
 	// PUT api to update file contents
 	router.PUT("/update", controller.UpdateFileContents)
 
@@ -17,4 +17,7 @@ func MemberRoutes(router *gin.RouterGroup) {
 	// GET api to get the details of sinle github user
 	router.GET("/:proj/:name", controller.GetUserDetails)
 
+	// DELETE api to remove a member from the project, closes their realtime connections
+	router.DELETE("/:proj/:user_id", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.RemoveMember)
+
 }
@@ -23,8 +23,11 @@ func ProjectRoutes(router *gin.RouterGroup) {
 	// POST route to get access token for github installation
 	router.POST("/installation/access_token/:id", controller.GetAccessTokenForGithubAppInstallation)
 
-	// Route for long polling (user waiting for project updates)
-	router.GET("/:projectID/updates", utils.HandleWebSocket)
+	// Route for WebSocket connection (user waiting for project updates)
+	router.GET("/:projectID/updates", middleware.ProjectMemberMiddleware, utils.HandleWebSocket)
+
+	// Route for long polling, fallback for clients that can't use WebSocket
+	router.GET("/:projectID/poll", middleware.ProjectMemberMiddleware, utils.LongPollHandler)
 
 	// Route to get the drawings
 	router.GET("/drawings", controller.GetDrawingsProjects)
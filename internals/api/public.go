@@ -12,5 +12,29 @@ func PublicRoutes(router *gin.RouterGroup) {
 
 	router.GET("/:name/file/:id", controller.GetPublicFile)
 
+	// GET api to list the published versions of a documentation
+	router.GET("/:name/versions", controller.GetPublicVersions)
+
+	// GET apis to serve a specific version
+	router.GET("/:name/v/:version/folder", controller.GetPublicVersionFolder)
+
+	router.GET("/:name/v/:version/file/:id", controller.GetPublicVersionFile)
+
+	// GET api to render a drawing published with the docs
+	router.GET("/:name/drawing/:project/:drawing/render", controller.RenderPublicDrawing)
+
+	// GET apis to serve the preview of an editing branch, the token is its only key
+	router.GET("/:name/preview/:token/folder", controller.GetPreviewFolder)
+
+	router.GET("/:name/preview/:token/file/:id", controller.GetPreviewFile)
+
+	router.GET("/:name/preview/:token/drawing/:project/:drawing/render", controller.RenderPreviewDrawing)
+
 	router.POST("/publish", middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.PublishDocs)
+
+	// POST api to take the public docs down
+	router.POST("/unpublish", middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.UnpublishDocs)
+
+	// POST api to clear the public docs and publish them again
+	router.POST("/republish", middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.RepublishDocs)
 }
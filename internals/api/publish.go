@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+)
+
+func PublishRoutes(router *gin.RouterGroup) {
+	router.Use(middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor, models.RoleViever}))
+
+	// GET api to get the status and progress of a publish job
+	router.GET("/jobs/:id", controller.GetPublishJob)
+
+	// GET api to list the latest publish jobs of a project
+	router.GET("/jobs/list/:id", controller.ListPublishJobs)
+
+	// GET api to list the deploys of the public docs that can be rolled back to
+	router.GET("/deploys/:id", controller.ListDeploys)
+
+	// POST api to publish an editing branch to a preview
+	router.POST("/preview", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}), controller.PublishPreview)
+
+	// GET api to list the live previews of a project
+	router.GET("/previews/:id", controller.ListPreviews)
+
+	// POST api to switch the public docs back to an earlier deploy
+	router.POST("/rollback", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.RollbackDeploy)
+}
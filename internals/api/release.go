@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+)
+
+func ReleaseRoutes(router *gin.RouterGroup) {
+	router.Use(middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor, models.RoleViever}))
+
+	// GET api to list the releases of a project
+	router.GET("/list/:id", controller.ListReleases)
+
+	// POST api to cut a release from main as a tag or release branch
+	router.POST("", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.CreateRelease)
+
+	// POST api to publish a release under <slug>/<version>/
+	router.POST("/publish", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.PublishRelease)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+)
+
+func ReviewRoutes(router *gin.RouterGroup) {
+	router.Use(middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin, models.RoleEditor}))
+
+	// POST api to request a review of an editing branch
+	router.POST("", controller.RequestReview)
+
+	// GET api to list the review requests of a project
+	router.GET("/list/:id", controller.ListReviews)
+
+	// POST api to assign more reviewers
+	router.POST("/reviewers", controller.AddReviewers)
+
+	// POST api to approve or request changes
+	router.POST("/decision", controller.DecideReview)
+
+	// PUT api to set how many Admin approvals a merge needs
+	router.PUT("/approvals", middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}), controller.SetRequiredApprovals)
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"github.com/Akshdhiwar/simpledocs-backend/internals/controller"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/middleware"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+)
+
+func WebhookRoutes(router *gin.RouterGroup) {
+	router.Use(middleware.AuthMiddleware, middleware.RoleMiddleware([]models.UserRole{models.RoleAdmin}))
+
+	// GET api to list the GitHub webhook deliveries of a project, failed ones by default
+	router.GET("/deliveries/:id", controller.ListWebhookDeliveries)
+
+	// POST api to replay a failed delivery
+	router.POST("/deliveries/replay", controller.ReplayWebhookDelivery)
+}
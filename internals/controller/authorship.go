@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CommitIdentity is the author or committer of a commit as GitHub takes it
+type CommitIdentity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// commitIdentity builds the identity of a user from their users row. GitHub
+// users commit with their noreply address, the email they logged in with is
+// private and would end up in the repo history. Only users without a GitHub
+// account, like Google users, commit with their stored email.
+func commitIdentity(userID string) (CommitIdentity, error) {
+	var name, githubName, email string
+	var githubID int
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT COALESCE(name, ''), COALESCE(github_name, ''), COALESCE(email, ''), COALESCE(github_id, 0)
+		FROM users WHERE id = $1
+	`, userID).Scan(&name, &githubName, &email, &githubID)
+	if err != nil {
+		return CommitIdentity{}, err
+	}
+
+	identity := CommitIdentity{Name: name, Email: email}
+
+	if identity.Name == "" {
+		identity.Name = githubName
+	}
+	if githubID != 0 && githubName != "" {
+		identity.Email = fmt.Sprintf("%d+%s@users.noreply.github.com", githubID, githubName)
+	}
+	if identity.Name == "" {
+		identity.Name = strings.Split(identity.Email, "@")[0]
+	}
+
+	if identity.Name == "" || identity.Email == "" {
+		return identity, fmt.Errorf("user %s has no name or email to commit with", userID)
+	}
+
+	return identity, nil
+}
+
+// setCommitIdentity sets author and committer of a commit payload to the user
+// acting in the request. Without it GitHub credits whoever owns the token,
+// which for Google users is the project owner.
+func setCommitIdentity(ctx *gin.Context, payload map[string]interface{}) {
+	identity, err := commitIdentity(ctx.GetHeader("X-User-Id"))
+	if err != nil {
+		fmt.Println("Error getting commit identity, GitHub will use the token owner:", err)
+		return
+	}
+
+	payload["author"] = identity
+	payload["committer"] = identity
+}
+
+// withCoAuthors adds a Co-authored-by trailer for every other user whose
+// changes went into the commit
+func withCoAuthors(message, authorID string, coAuthors []string) string {
+	var trailers []string
+	seen := map[string]bool{authorID: true}
+
+	for _, id := range coAuthors {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		identity, err := commitIdentity(id)
+		if err != nil {
+			fmt.Println("Error getting co-author identity:", err)
+			continue
+		}
+		trailers = append(trailers, fmt.Sprintf("Co-authored-by: %s <%s>", identity.Name, identity.Email))
+	}
+
+	if len(trailers) == 0 {
+		return message
+	}
+
+	return strings.TrimRight(message, "\n") + "\n\n" + strings.Join(trailers, "\n")
+}
+
+// recordCommitActivity keeps who made a commit, GitHub only knows the token
+func recordCommitActivity(projectID, userID, branch, sha, message string, coAuthors []string) {
+	if sha == "" {
+		return
+	}
+
+	others := []string{}
+	for _, id := range coAuthors {
+		if id != userID {
+			others = append(others, id)
+		}
+	}
+
+	_, err := initializer.DB.Exec(context.Background(), `
+		INSERT INTO commit_activity (project_id, user_id, branch_name, sha, message, co_authors)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (project_id, sha) DO NOTHING
+	`, projectID, userID, branch, sha, message, others)
+	if err != nil {
+		fmt.Println("Error recording commit activity:", err)
+	}
+
+	// Approvals of a branch were given for what it had before this commit
+	if branch != "main" {
+		resetBranchApprovals(projectID, branch)
+	}
+}
+
+// copyCommitActivity credits a replayed commit to whoever made the original
+func copyCommitActivity(projectID, branch, oldSha, newSha string) {
+	_, err := initializer.DB.Exec(context.Background(), `
+		INSERT INTO commit_activity (project_id, user_id, branch_name, sha, message, co_authors)
+		SELECT project_id, user_id, $2, $4, message, co_authors
+		FROM commit_activity
+		WHERE project_id = $1 AND sha = $3
+		ON CONFLICT (project_id, sha) DO NOTHING
+	`, projectID, branch, oldSha, newSha)
+	if err != nil {
+		fmt.Println("Error copying commit activity:", err)
+	}
+}
+
+// recordContentsCommit records a commit made through the contents API on
+// branch of the project
+func recordContentsCommit(ctx *gin.Context, projectID, branch string, resp *http.Response) {
+	var body struct {
+		Commit struct {
+			Sha     string `json:"sha"`
+			Message string `json:"message"`
+		} `json:"commit"`
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || json.Unmarshal(data, &body) != nil {
+		return
+	}
+
+	recordCommitActivity(projectID, ctx.GetHeader("X-User-Id"), branch, body.Commit.Sha, body.Commit.Message, nil)
+}
+
+// CommitActivity is a commit along with the users who made it
+type CommitActivity struct {
+	Sha        string    `json:"sha"`
+	BranchName string    `json:"branch_name"`
+	Message    string    `json:"message"`
+	UserID     string    `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	AvatarURL  string    `json:"avatar_url"`
+	CoAuthors  []string  `json:"co_authors"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// GetCommitActivity lists the latest commits of a project with who made them,
+// ?user= and ?branch= filter and ?limit= caps the list (50 by default)
+func GetCommitActivity(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(ctx.Query("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT ca.sha, ca.branch_name, ca.message, ca.user_id,
+			COALESCE(NULLIF(u.name, ''), u.github_name, u.email, ''), COALESCE(u.avatar_url, ''),
+			ca.co_authors, ca.created_at
+		FROM commit_activity ca
+		JOIN users u ON u.id = ca.user_id
+		WHERE ca.project_id = $1
+			AND ($2 = '' OR ca.user_id::text = $2)
+			AND ($3 = '' OR ca.branch_name = $3)
+		ORDER BY ca.created_at DESC
+		LIMIT $4
+	`, projectId, ctx.Query("user"), ctx.Query("branch"), limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting commit activity from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	activity := []CommitActivity{}
+	for rows.Next() {
+		var a CommitActivity
+		var coAuthors []uuid.UUID
+		if err := rows.Scan(&a.Sha, &a.BranchName, &a.Message, &a.UserID, &a.UserName, &a.AvatarURL, &coAuthors, &a.CreatedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning commit activity : " + err.Error(),
+			})
+			return
+		}
+
+		a.CoAuthors = []string{}
+		for _, id := range coAuthors {
+			a.CoAuthors = append(a.CoAuthors, id.String())
+		}
+		activity = append(activity, a)
+	}
+
+	ctx.JSON(http.StatusOK, activity)
+}
@@ -7,15 +7,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
 	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
-var EditingBranchesMappings = make(map[string]string)
-
 func CreateBranchForEditing(ctx *gin.Context) {
 	var body struct {
 		ProjectID  string `json:"project_id"`
@@ -114,7 +116,32 @@ func createBranch(ctx *gin.Context, projectName, userName, org, newBranch, proje
 		return
 	}
 
-	EditingBranchesMappings[projectID+userID] = newBranch
+	// The new branch becomes the one the user is editing on
+	_, err = initializer.DB.Exec(context.Background(), `
+		WITH unset AS (
+			UPDATE editing_branches SET is_current = false
+			WHERE project_id = $1 AND user_id = $2 AND branch_name <> $3
+		)
+		INSERT INTO editing_branches (project_id, user_id, branch_name, base_sha, status, is_current)
+		VALUES ($1, $2, $3, $4, $5, true)
+		ON CONFLICT (project_id, branch_name) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			base_sha = EXCLUDED.base_sha,
+			status = EXCLUDED.status,
+			is_current = true,
+			created_at = now(),
+			updated_at = now()
+	`, projectID, userID, newBranch, latestCommistSha, models.BranchActive)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Branch created but saving it failed : " + err.Error(),
+		})
+		return
+	}
+
+	utils.BroadcastProjectEvent(projectID, userID, utils.EventBranchCreated, utils.BranchData{
+		BranchName: newBranch,
+	})
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": fmt.Sprintf("Branch %s created successfully", newBranch),
@@ -193,8 +220,9 @@ func deleteBranch(ctx *gin.Context, projectName, userName, org, branchName, proj
 
 	// Check if the response status is 204 No Content (success)
 	if resp.StatusCode == http.StatusNoContent {
+		setEditingBranchStatus(projectId, branchName, models.BranchDeleted)
+		expireBranchPreviews(projectId, branchName)
 		ctx.JSON(http.StatusOK, gin.H{"success": "Branch deleted successfully"})
-		delete(EditingBranchesMappings, projectId+userID)
 		return
 	}
 
@@ -206,6 +234,37 @@ func deleteBranch(ctx *gin.Context, projectName, userName, org, branchName, proj
 	})
 }
 
+// deleteGithubBranch deletes a branch on GitHub, a branch that is already gone is fine
+func deleteGithubBranch(ctx *gin.Context, projectName, userName, org, branchName string) error {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs/heads/%s", userName, projectName, branchName)
+	if org != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs/heads/%s", org, projectName, branchName)
+	}
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusUnprocessableEntity {
+		return fmt.Errorf("failed to delete branch: %s", resp.Status)
+	}
+
+	return nil
+}
+
 func CheckIfEditingBranchExists(ctx *gin.Context) {
 
 	projectID := ctx.Param("id")
@@ -217,11 +276,625 @@ func CheckIfEditingBranchExists(ctx *gin.Context) {
 		return
 	}
 
-	branchName, exists := EditingBranchesMappings[projectId.String()+userID]
-	if !exists {
+	var branchName string
+
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT branch_name FROM editing_branches
+		WHERE project_id = $1 AND user_id = $2 AND status = $3
+		ORDER BY is_current DESC, created_at DESC
+		LIMIT 1
+	`, projectId, userID, models.BranchActive).Scan(&branchName)
+	if err == pgx.ErrNoRows {
 		ctx.JSON(http.StatusOK, gin.H{"branch_name": ""})
-	} else {
-		ctx.JSON(http.StatusOK, gin.H{"branch_name": branchName})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting editing branch from DB : " + err.Error(),
+		})
+		return
 	}
 
+	ctx.JSON(http.StatusOK, gin.H{"branch_name": branchName})
+
+}
+
+// setEditingBranchStatus records what happened to an editing branch
+func setEditingBranchStatus(projectID, branchName string, status models.BranchStatus) {
+	_, err := initializer.DB.Exec(context.Background(), `
+		UPDATE editing_branches SET status = $1, updated_at = now()
+		WHERE project_id = $2 AND branch_name = $3
+	`, status, projectID, branchName)
+	if err != nil {
+		fmt.Println("Error updating editing branch status:", err)
+	}
+}
+
+// ReconcileEditingBranches compares the editing branches we know about with
+// the branches on GitHub. Branches deleted on GitHub are marked missing and
+// missing ones that came back are active again.
+func ReconcileEditingBranches() {
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT DISTINCT
+			p.id,
+			p.name,
+			COALESCE(p.org, ''),
+			u.id,
+			u.github_name
+		FROM editing_branches eb
+		JOIN projects p ON eb.project_id = p.id
+		JOIN users u ON p.owner = u.id
+		WHERE eb.status IN ($1, $2, $3)
+	`, models.BranchActive, models.BranchInReview, models.BranchMissing)
+	if err != nil {
+		fmt.Println("Error getting projects to reconcile branches:", err)
+		return
+	}
+
+	type project struct {
+		ID, Name, Org, OwnerID, OwnerName string
+	}
+
+	var projects []project
+	for rows.Next() {
+		var p project
+		if err := rows.Scan(&p.ID, &p.Name, &p.Org, &p.OwnerID, &p.OwnerName); err != nil {
+			fmt.Println("Error scanning project to reconcile branches:", err)
+			continue
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+
+	for _, p := range projects {
+		owner := p.OwnerName
+		if p.Org != "" {
+			owner = p.Org
+		}
+
+		token, err := utils.GetAccessTokenForUser(p.OwnerID)
+		if err != nil {
+			fmt.Printf("Error getting token to reconcile branches of %s: %v\n", p.Name, err)
+			continue
+		}
+
+		branches, err := listGithubBranches(token, owner, p.Name)
+		if err != nil {
+			fmt.Printf("Error listing branches of %s: %v\n", p.Name, err)
+			continue
+		}
+
+		// Branches no longer on GitHub
+		_, err = initializer.DB.Exec(context.Background(), `
+			UPDATE editing_branches SET status = $1, updated_at = now()
+			WHERE project_id = $2 AND status IN ($3, $4) AND NOT (branch_name = ANY($5))
+		`, models.BranchMissing, p.ID, models.BranchActive, models.BranchInReview, branches)
+		if err != nil {
+			fmt.Println("Error marking missing branches:", err)
+		}
+
+		// Branches that showed up again, e.g. restored from the pull request page
+		_, err = initializer.DB.Exec(context.Background(), `
+			UPDATE editing_branches SET status = $1, updated_at = now()
+			WHERE project_id = $2 AND status = $3 AND branch_name = ANY($4)
+		`, models.BranchActive, p.ID, models.BranchMissing, branches)
+		if err != nil {
+			fmt.Println("Error restoring branches:", err)
+		}
+	}
+}
+
+// listGithubBranches returns the names of every branch of the repository
+func listGithubBranches(token, owner, repo string) ([]string, error) {
+	branches := []string{}
+
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches?per_page=100&page=%d", owner, repo, page)
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new HTTP request: %w", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list branches: %s", resp.Status)
+		}
+
+		var result []struct {
+			Name string `json:"name"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response body: %w", err)
+		}
+
+		for _, b := range result {
+			branches = append(branches, b.Name)
+		}
+
+		if len(result) < 100 {
+			return branches, nil
+		}
+	}
+}
+
+// CompareResponse is the part of the GitHub compare API we use
+type CompareResponse struct {
+	MergeBaseCommit struct {
+		Sha string `json:"sha"`
+	} `json:"merge_base_commit"`
+	Status       string `json:"status"`
+	AheadBy      int    `json:"ahead_by"`
+	BehindBy     int    `json:"behind_by"`
+	TotalCommits int    `json:"total_commits"`
+	Commits      []struct {
+		Sha     string `json:"sha"`
+		Parents []struct {
+			Sha string `json:"sha"`
+		} `json:"parents"`
+		Commit struct {
+			Message string `json:"message"`
+			Author  struct {
+				Name  string `json:"name"`
+				Email string `json:"email"`
+				Date  string `json:"date"`
+			} `json:"author"`
+		} `json:"commit"`
+	} `json:"commits"`
+	Files []struct {
+		Filename         string `json:"filename"`
+		PreviousFilename string `json:"previous_filename"`
+		Status           string `json:"status"`
+		Additions        int    `json:"additions"`
+		Deletions        int    `json:"deletions"`
+	} `json:"files"`
+}
+
+// compareBranches compares head with base, ahead_by counts the commits of
+// head that are not on base
+func compareBranches(ctx *gin.Context, repoName, userName, org, base, head string) (CompareResponse, error) {
+	var compare CompareResponse
+
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/compare/%s...%s", userName, repoName, base, head)
+	if org != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/compare/%s...%s", org, repoName, base, head)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return compare, fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		return compare, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return compare, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return compare, fmt.Errorf("failed to compare branches: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&compare); err != nil {
+		return compare, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	return compare, nil
+}
+
+// ListBranches lists the editing branches of a project with their owner and
+// how far they are ahead and behind main
+func ListBranches(ctx *gin.Context) {
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT
+			eb.branch_name,
+			eb.base_sha,
+			eb.status,
+			eb.is_current AND eb.user_id = $2,
+			eb.created_at,
+			u.id,
+			COALESCE(u.name, ''),
+			COALESCE(u.github_name, ''),
+			COALESCE(u.avatar_url, '')
+		FROM editing_branches eb
+		JOIN users u ON eb.user_id = u.id
+		WHERE eb.project_id = $1 AND eb.status IN ($3, $4)
+		ORDER BY eb.created_at DESC
+	`, projectId, userID, models.BranchActive, models.BranchInReview)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branches from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	type owner struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		GithubName string `json:"github_name"`
+		Avatar     string `json:"avatar_url"`
+	}
+
+	type branch struct {
+		Name      string    `json:"branch_name"`
+		BaseSha   string    `json:"base_sha"`
+		Status    string    `json:"status"`
+		IsCurrent bool      `json:"is_current"`
+		CreatedAt time.Time `json:"created_at"`
+		Owner     owner     `json:"owner"`
+		AheadBy   int       `json:"ahead_by"`
+		BehindBy  int       `json:"behind_by"`
+		Error     string    `json:"error,omitempty"`
+	}
+
+	branches := []branch{}
+	for rows.Next() {
+		var b branch
+		if err := rows.Scan(&b.Name, &b.BaseSha, &b.Status, &b.IsCurrent, &b.CreatedAt, &b.Owner.ID, &b.Owner.Name, &b.Owner.GithubName, &b.Owner.Avatar); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning branches : " + err.Error(),
+			})
+			return
+		}
+		branches = append(branches, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branches from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// One failing branch (e.g. deleted on GitHub) shouldn't hide the others
+	for i := range branches {
+		compare, err := compareBranches(ctx, projectName, userName, org, "main", branches[i].Name)
+		if err != nil {
+			branches[i].Error = err.Error()
+			continue
+		}
+		branches[i].AheadBy = compare.AheadBy
+		branches[i].BehindBy = compare.BehindBy
+	}
+
+	ctx.JSON(http.StatusOK, branches)
+}
+
+// SwitchBranch makes another of the user's active branches the one they edit on
+func SwitchBranch(ctx *gin.Context) {
+	var body struct {
+		ProjectID  string `json:"project_id"`
+		BranchName string `json:"branch_name"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	result, err := initializer.DB.Exec(context.Background(), `
+		UPDATE editing_branches SET is_current = (branch_name = $3)
+		WHERE project_id = $1 AND user_id = $2
+			AND EXISTS (
+				SELECT 1 FROM editing_branches
+				WHERE project_id = $1 AND user_id = $2 AND branch_name = $3 AND status = $4
+			)
+	`, projectId, userID, body.BranchName, models.BranchActive)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error switching branch : " + err.Error(),
+		})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		ctx.JSON(http.StatusNotFound, "You have no active branch named "+body.BranchName)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"branch_name": body.BranchName})
+}
+
+// activeBranchOf tells if the branch is an active editing branch of the user
+func activeBranchOf(projectID uuid.UUID, userID, branchName string) (bool, error) {
+	var active bool
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (
+			SELECT 1 FROM editing_branches
+			WHERE project_id = $1 AND user_id::text = $2 AND branch_name = $3 AND status = $4
+		)
+	`, projectID, userID, branchName, models.BranchActive).Scan(&active)
+	return active, err
+}
+
+// RenameBranch renames one of the user's branches on GitHub and in the DB
+func RenameBranch(ctx *gin.Context) {
+	var body struct {
+		ProjectID  string `json:"project_id"`
+		BranchName string `json:"branch_name"`
+		NewName    string `json:"new_name"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if body.NewName == "" || body.BranchName == "main" || body.NewName == "main" {
+		ctx.JSON(http.StatusBadRequest, "Invalid branch name")
+		return
+	}
+
+	var owner string
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT user_id FROM editing_branches WHERE project_id = $1 AND branch_name = $2 AND status = $3
+	`, projectId, body.BranchName, models.BranchActive).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Branch not found")
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if owner != userID {
+		ctx.JSON(http.StatusForbidden, "Only the owner of the branch can rename it")
+		return
+	}
+
+	// The branch names are unique per project whatever their status, check
+	// before GitHub renames anything
+	var taken bool
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (SELECT 1 FROM editing_branches WHERE project_id = $1 AND branch_name = $2)
+	`, projectId, body.NewName).Scan(&taken)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return
+	}
+	if taken {
+		ctx.JSON(http.StatusConflict, "A branch named "+body.NewName+" already exists")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// Queued saves go to the branch under its current name
+	if err := flushQueuedCommit(projectId.String(), body.BranchName); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error committing queued saves : " + err.Error(),
+		})
+		return
+	}
+
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches/%s/rename", userName, projectName, body.BranchName)
+	if org != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/branches/%s/rename", org, projectName, body.BranchName)
+	}
+
+	requestBody, err := json.Marshal(map[string]string{
+		"new_name": body.NewName,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("Error renaming branch: %s", string(respBody)))
+		return
+	}
+
+	// Everything kept by branch name moves along with it
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Branch renamed but saving it failed : " + err.Error(),
+		})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	for _, query := range []string{
+		`UPDATE editing_branches SET branch_name = $3, updated_at = now() WHERE project_id = $1 AND branch_name = $2`,
+		`UPDATE previews SET branch_name = $3, updated_at = now() WHERE project_id = $1 AND branch_name = $2 AND expired_at IS NULL`,
+		`UPDATE review_requests SET branch_name = $3, updated_at = now() WHERE project_id = $1 AND branch_name = $2`,
+		`UPDATE commit_queue SET branch_name = $3 WHERE project_id = $1 AND branch_name = $2`,
+		`UPDATE commit_activity SET branch_name = $3 WHERE project_id = $1 AND branch_name = $2`,
+	} {
+		if _, err = tx.Exec(context.Background(), query, projectId, body.BranchName, body.NewName); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Branch renamed but saving it failed : " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if err = tx.Commit(context.Background()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Branch renamed but saving it failed : " + err.Error(),
+		})
+		return
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventBranchRenamed, utils.BranchRenamedData{
+		OldName:    body.BranchName,
+		BranchName: body.NewName,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"branch_name": body.NewName})
+}
+
+// ChangedPage is a page that differs between two branches
+type ChangedPage struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Status string `json:"status"` // added, modified, removed or renamed as reported by GitHub
+	Path   string `json:"path"`
+}
+
+// pageIDFromPath returns the page id of a Documentthing/files/<id>.json path
+func pageIDFromPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, "Documentthing/files/") || !strings.HasSuffix(path, ".json") {
+		return "", false
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(path, "Documentthing/files/"), ".json"), true
+}
+
+// CompareBranch lists the pages changed on a branch (?branch=) compared with main
+func CompareBranch(ctx *gin.Context) {
+	userID := ctx.GetHeader("X-User-Id")
+	branchName := ctx.Query("branch")
+
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if branchName == "" {
+		ctx.JSON(http.StatusBadRequest, "Branch is required")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	compare, err := compareBranches(ctx, projectName, userName, org, "main", branchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Titles come from the branch, pages removed on it only exist on main
+	titles := make(map[string]string)
+	if mainFolders, err := getFolderTree(ctx, projectName, userName, org, "main"); err == nil {
+		folderTitles(mainFolders, titles)
+	}
+	if branchFolders, err := getFolderTree(ctx, projectName, userName, org, branchName); err == nil {
+		folderTitles(branchFolders, titles)
+	}
+
+	pages := []ChangedPage{}
+	otherFiles := []string{}
+	folderChanged := false
+
+	for _, f := range compare.Files {
+		if f.Filename == "Documentthing/folder/folder.json" {
+			folderChanged = true
+			continue
+		}
+
+		id, ok := pageIDFromPath(f.Filename)
+		if !ok {
+			otherFiles = append(otherFiles, f.Filename)
+			continue
+		}
+
+		pages = append(pages, ChangedPage{
+			ID:     id,
+			Title:  titles[id],
+			Status: f.Status,
+			Path:   f.Filename,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"branch_name":    branchName,
+		"status":         compare.Status,
+		"ahead_by":       compare.AheadBy,
+		"behind_by":      compare.BehindBy,
+		"pages":          pages,
+		"folder_changed": folderChanged,
+		"other_files":    otherFiles,
+	})
 }
@@ -0,0 +1,538 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxChangelogCommits caps how many commits a changelog reads, every commit
+// costs a GitHub request
+const maxChangelogCommits = 300
+
+// ChangelogPage is a page that changed in the range along with who changed it
+type ChangelogPage struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Authors []string `json:"authors"`
+}
+
+// ChangelogAuthor is what one author changed in the range
+type ChangelogAuthor struct {
+	Name    string   `json:"name"`
+	New     []string `json:"new"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+}
+
+type Changelog struct {
+	Since     time.Time         `json:"since"`
+	Until     time.Time         `json:"until"`
+	Commits   int               `json:"commits"`
+	Truncated bool              `json:"truncated"` // More commits than maxChangelogCommits
+	New       []ChangelogPage   `json:"new"`
+	Updated   []ChangelogPage   `json:"updated"`
+	Removed   []ChangelogPage   `json:"removed"`
+	Authors   []ChangelogAuthor `json:"authors"`
+}
+
+type githubCommit struct {
+	Sha    string `json:"sha"`
+	Commit struct {
+		Author struct {
+			Name string    `json:"name"`
+			Date time.Time `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+	Parents []struct {
+		Sha string `json:"sha"`
+	} `json:"parents"`
+	Files []struct {
+		Filename         string `json:"filename"`
+		PreviousFilename string `json:"previous_filename"`
+		Status           string `json:"status"`
+	} `json:"files"`
+}
+
+// githubGet does an authenticated GET on the GitHub API and decodes the response
+func githubGet(ctx *gin.Context, url string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// listCommits lists the commits of a branch in the range, newest first
+func listCommits(ctx *gin.Context, repoName, userName, org, branch string, since, until time.Time) ([]githubCommit, bool, error) {
+	owner := userName
+	if org != "" {
+		owner = org
+	}
+
+	var commits []githubCommit
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits?sha=%s&since=%s&until=%s&per_page=100&page=%d",
+			owner, repoName, branch, since.UTC().Format(time.RFC3339), until.UTC().Format(time.RFC3339), page)
+
+		var batch []githubCommit
+		if err := githubGet(ctx, url, &batch); err != nil {
+			return nil, false, err
+		}
+
+		commits = append(commits, batch...)
+		if len(commits) >= maxChangelogCommits {
+			return commits[:maxChangelogCommits], true, nil
+		}
+		if len(batch) < 100 {
+			return commits, false, nil
+		}
+	}
+}
+
+// buildChangelog walks the commits on main in the range and sorts the pages
+// they touched into new, updated and removed. A page added and removed
+// within the range is left out.
+func buildChangelog(ctx *gin.Context, projectName, userName, org string, since, until time.Time) (Changelog, error) {
+	changelog := Changelog{
+		Since:   since,
+		Until:   until,
+		New:     []ChangelogPage{},
+		Updated: []ChangelogPage{},
+		Removed: []ChangelogPage{},
+		Authors: []ChangelogAuthor{},
+	}
+
+	commits, truncated, err := listCommits(ctx, projectName, userName, org, "main", since, until)
+	if err != nil {
+		return changelog, err
+	}
+
+	changelog.Commits = len(commits)
+	changelog.Truncated = truncated
+
+	if len(commits) == 0 {
+		return changelog, nil
+	}
+
+	owner := userName
+	if org != "" {
+		owner = org
+	}
+
+	type pageState struct {
+		added, removed bool
+		authors        []string
+	}
+	pages := make(map[string]*pageState)
+	var order []string
+
+	// Oldest first so the last status of a page is the one that counts
+	for i := len(commits) - 1; i >= 0; i-- {
+		// Merge commits repeat the changes of the commits they bring in
+		if len(commits[i].Parents) > 1 {
+			continue
+		}
+
+		var commit githubCommit
+		url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s", owner, projectName, commits[i].Sha)
+		if err := githubGet(ctx, url, &commit); err != nil {
+			return changelog, err
+		}
+
+		author := commit.Commit.Author.Name
+		for _, file := range commit.Files {
+			id, ok := pageIDFromPath(file.Filename)
+			if !ok {
+				continue
+			}
+
+			state, seen := pages[id]
+			if !seen {
+				state = &pageState{added: file.Status == "added"}
+				pages[id] = state
+				order = append(order, id)
+			}
+
+			state.removed = file.Status == "removed"
+			if author != "" && !containsString(state.authors, author) {
+				state.authors = append(state.authors, author)
+			}
+		}
+	}
+
+	// Titles of current pages come from the end of the range, removed ones
+	// from before it
+	endFolders, err := getFolderTree(ctx, projectName, userName, org, commits[0].Sha)
+	if err != nil {
+		fmt.Println("Error getting folder structure for changelog:", err)
+	}
+	endTitles := folderTitles(endFolders, nil)
+
+	var startTitles map[string]string
+	if oldest := commits[len(commits)-1]; len(oldest.Parents) > 0 {
+		startFolders, err := getFolderTree(ctx, projectName, userName, org, oldest.Parents[0].Sha)
+		if err != nil {
+			fmt.Println("Error getting folder structure for changelog:", err)
+		}
+		startTitles = folderTitles(startFolders, nil)
+	}
+
+	authors := make(map[string]*ChangelogAuthor)
+	var authorOrder []string
+	authorFor := func(name string) *ChangelogAuthor {
+		if a, ok := authors[name]; ok {
+			return a
+		}
+		a := &ChangelogAuthor{Name: name, New: []string{}, Updated: []string{}, Removed: []string{}}
+		authors[name] = a
+		authorOrder = append(authorOrder, name)
+		return a
+	}
+
+	for _, id := range order {
+		state := pages[id]
+		page := ChangelogPage{ID: id, Authors: state.authors}
+
+		switch {
+		case state.added && state.removed:
+			continue
+		case state.added:
+			page.Title = titleOr(endTitles[id], id)
+			changelog.New = append(changelog.New, page)
+			for _, name := range state.authors {
+				a := authorFor(name)
+				a.New = append(a.New, page.Title)
+			}
+		case state.removed:
+			page.Title = titleOr(startTitles[id], id)
+			changelog.Removed = append(changelog.Removed, page)
+			for _, name := range state.authors {
+				a := authorFor(name)
+				a.Removed = append(a.Removed, page.Title)
+			}
+		default:
+			page.Title = titleOr(endTitles[id], titleOr(startTitles[id], id))
+			changelog.Updated = append(changelog.Updated, page)
+			for _, name := range state.authors {
+				a := authorFor(name)
+				a.Updated = append(a.Updated, page.Title)
+			}
+		}
+	}
+
+	sort.Strings(authorOrder)
+	for _, name := range authorOrder {
+		changelog.Authors = append(changelog.Authors, *authors[name])
+	}
+
+	return changelog, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown renders the changelog for people, grouped by what happened to the page
+func (c Changelog) Markdown(projectName string) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("# %s documentation changes\n\n", projectName))
+	b.WriteString(fmt.Sprintf("%s to %s\n", c.Since.Format("Jan 2, 2006"), c.Until.Format("Jan 2, 2006")))
+
+	if len(c.New)+len(c.Updated)+len(c.Removed) == 0 {
+		b.WriteString("\nNo pages changed.\n")
+		return b.String()
+	}
+
+	writePages := func(heading string, pages []ChangelogPage) {
+		if len(pages) == 0 {
+			return
+		}
+		b.WriteString(fmt.Sprintf("\n## %s\n\n", heading))
+		for _, page := range pages {
+			if len(page.Authors) > 0 {
+				b.WriteString(fmt.Sprintf("- %s (by %s)\n", page.Title, strings.Join(page.Authors, ", ")))
+			} else {
+				b.WriteString(fmt.Sprintf("- %s\n", page.Title))
+			}
+		}
+	}
+
+	writePages("New pages", c.New)
+	writePages("Updated pages", c.Updated)
+	writePages("Removed pages", c.Removed)
+
+	if c.Truncated {
+		b.WriteString(fmt.Sprintf("\n_Only the latest %d commits were included._\n", maxChangelogCommits))
+	}
+
+	return b.String()
+}
+
+// parseChangelogTime accepts a date or a RFC3339 time
+func parseChangelogTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// GetChangelog builds the changelog of main between ?since= and ?until=
+// (last 7 days by default), ?format=markdown returns it as Markdown
+func GetChangelog(ctx *gin.Context) {
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	now := time.Now()
+	until, err := parseChangelogTime(ctx.Query("until"), now)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Invalid until, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	since, err := parseChangelogTime(ctx.Query("since"), until.AddDate(0, 0, -7))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Invalid since, use YYYY-MM-DD or RFC3339")
+		return
+	}
+
+	if !since.Before(until) {
+		ctx.JSON(http.StatusBadRequest, "since must be before until")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	changelog, err := buildChangelog(ctx, projectName, userName, org, since, until)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if ctx.Query("format") == "markdown" {
+		ctx.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(changelog.Markdown(projectName)))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, changelog)
+}
+
+// SetWeeklyChangelog turns the weekly changelog of a project on or off
+func SetWeeklyChangelog(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+		Enabled   bool   `json:"enabled"`
+	}
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE projects SET weekly_changelog = $2 WHERE id = $1
+	`, projectId, body.Enabled)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating project : " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"enabled": body.Enabled})
+}
+
+// ListChangelogs lists the changelogs the weekly job saved for a project
+func ListChangelogs(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT id, since, until, markdown, data, created_at
+		FROM changelogs WHERE project_id = $1
+		ORDER BY until DESC
+		LIMIT 52
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting changelogs from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	type savedChangelog struct {
+		ID        uuid.UUID       `json:"id"`
+		Since     time.Time       `json:"since"`
+		Until     time.Time       `json:"until"`
+		Markdown  string          `json:"markdown"`
+		Changelog json.RawMessage `json:"changelog"`
+		CreatedAt time.Time       `json:"created_at"`
+	}
+
+	changelogs := []savedChangelog{}
+	for rows.Next() {
+		var c savedChangelog
+		if err := rows.Scan(&c.ID, &c.Since, &c.Until, &c.Markdown, &c.Changelog, &c.CreatedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning changelogs : " + err.Error(),
+			})
+			return
+		}
+		changelogs = append(changelogs, c)
+	}
+
+	ctx.JSON(http.StatusOK, changelogs)
+}
+
+// WeeklyChangelogs saves last week's changelog of every project that turned
+// it on and tells the project members about it
+func WeeklyChangelogs() {
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT p.id, p.name, COALESCE(p.org, ''), u.id, u.github_name
+		FROM projects p
+		JOIN users u ON p.owner = u.id
+		WHERE p.weekly_changelog = true AND p.deleted_at IS NULL
+	`)
+	if err != nil {
+		fmt.Println("Error getting projects for weekly changelog:", err)
+		return
+	}
+
+	type project struct {
+		ID, Name, Org, OwnerID, OwnerName string
+	}
+
+	var projects []project
+	for rows.Next() {
+		var p project
+		if err := rows.Scan(&p.ID, &p.Name, &p.Org, &p.OwnerID, &p.OwnerName); err != nil {
+			fmt.Println("Error scanning project for weekly changelog:", err)
+			continue
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+
+	until := time.Now()
+	since := until.AddDate(0, 0, -7)
+
+	for _, p := range projects {
+		// Another instance already saved it
+		var saved bool
+		err := initializer.DB.QueryRow(context.Background(), `
+			SELECT EXISTS (
+				SELECT 1 FROM changelogs
+				WHERE project_id = $1 AND (until AT TIME ZONE 'UTC')::date = ($2 AT TIME ZONE 'UTC')::date
+			)
+		`, p.ID, until).Scan(&saved)
+		if err != nil {
+			fmt.Printf("Error checking weekly changelog of %s: %v\n", p.Name, err)
+			continue
+		}
+		if saved {
+			continue
+		}
+
+		ctx := backgroundContext(p.OwnerID)
+
+		changelog, err := buildChangelog(ctx, p.Name, p.OwnerName, p.Org, since, until)
+		if err != nil {
+			fmt.Printf("Error building weekly changelog of %s: %v\n", p.Name, err)
+			continue
+		}
+
+		data, err := json.Marshal(changelog)
+		if err != nil {
+			fmt.Printf("Error encoding weekly changelog of %s: %v\n", p.Name, err)
+			continue
+		}
+
+		var id uuid.UUID
+		err = initializer.DB.QueryRow(context.Background(), `
+			INSERT INTO changelogs (project_id, since, until, markdown, data)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (project_id, ((until AT TIME ZONE 'UTC')::date)) DO NOTHING
+			RETURNING id
+		`, p.ID, since, until, changelog.Markdown(p.Name), data).Scan(&id)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Error saving weekly changelog of %s: %v\n", p.Name, err)
+			continue
+		}
+
+		utils.BroadcastProjectEvent(p.ID, "", utils.EventChangelog, utils.ChangelogData{
+			ID:    id.String(),
+			Since: since,
+			Until: until,
+		})
+	}
+}
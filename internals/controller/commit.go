@@ -20,6 +20,7 @@ func CommitChanges(ctx *gin.Context) {
 		ProjectID string     `json:"project_id"`
 		Content   []Contents `json:"content"`
 		Message   string     `json:"message"`
+		Flush     bool       `json:"flush"` // Commit right away even when batching
 	}
 
 	userID := ctx.GetHeader("X-User-Id")
@@ -61,42 +62,112 @@ func CommitChanges(ctx *gin.Context) {
 		return
 	}
 
-	latestCommistSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, "main")
+	queued, err := commitOrQueue(ctx, projectId, userID, userName, projectName, org, "main", body.Content, body.Message, body.Flush)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	latestCommitTreeSha, err := getLatestTreeShaForCommit(ctx, projectName, userName, org, latestCommistSha)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
+	if queued != nil {
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message":  "Changes queued",
+			"flush_at": queued,
+		})
 		return
 	}
 
-	latestTreeSha, err := createNewTreeForCommit(ctx, projectName, userName, org, latestCommitTreeSha, body.Content)
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Changes committed successfully",
+	})
+
+}
+
+// commitOrQueue commits the contents, or adds them to the commit queue when
+// the project batches saves. With flush the queued saves of the branch go
+// out together with these. It returns when the batch will be committed if
+// the contents were queued.
+func commitOrQueue(ctx *gin.Context, projectId uuid.UUID, userID, userName, projectName, org, branchName string, content []Contents, message string, flush bool) (*time.Time, error) {
+	window, err := getCommitBatchWindow(projectId)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
+		return nil, fmt.Errorf("failed to get commit batch window: %w", err)
+	}
+
+	if window == 0 && !flush {
+		sha, err := commitContents(ctx, projectName, userName, org, branchName, content, message)
+		if err != nil {
+			return nil, err
+		}
+		recordCommitActivity(projectId.String(), userID, branchName, sha, message, nil)
+
+		utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPageSaved, utils.PageSavedData{
+			Branch:  branchName,
+			Paths:   contentPaths(content),
+			Message: message,
+		})
+		return nil, nil
 	}
 
-	newCommitSha, err := createNewCommit(ctx, projectName, userName, org, latestTreeSha, latestCommistSha, body.Message)
+	if window == 0 {
+		window = time.Minute
+	}
+
+	flushAt, err := queueCommit(projectId.String(), branchName, userID, userName, projectName, org, content, message, window)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
+	}
+	if !flush {
+		return &flushAt, nil
+	}
+
+	return nil, flushQueuedCommit(projectId.String(), branchName)
+}
+
+// getProjectDetails returns the github name of the user along with the repo
+// name and org of the project, the user must be a member of the project
+func getProjectDetails(projectID uuid.UUID, userID string) (userName, projectName, org string, err error) {
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT 
+		u.github_name,
+		p.name AS project_name,
+		COALESCE(p.org, '') AS project_org
+	FROM 
+		user_project_mapping upm
+	JOIN 
+		users u ON upm.user_id = u.id
+	JOIN 
+		projects p ON upm.project_id = p.id
+	WHERE 
+		p.id = $1
+		AND u.id = $2;
+		`, projectID, userID).Scan(&userName, &projectName, &org)
+
+	return userName, projectName, org, err
+}
+
+// commitContents commits the contents on top of the head of the branch and
+// moves the branch to the new commit, it returns the sha of the commit
+func commitContents(ctx *gin.Context, projectName, userName, org, branchName string, content []Contents, message string, coAuthors ...string) (string, error) {
+	latestCommistSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, branchName)
+	if err != nil {
+		return "", err
 	}
 
-	err = updateReferenceToNewCommit(ctx, projectName, userName, org, newCommitSha, "main")
+	latestCommitTreeSha, err := getLatestTreeShaForCommit(ctx, projectName, userName, org, latestCommistSha)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
+		return "", err
 	}
 
-	utils.NotifyUsers(body.ProjectID, ctx.GetHeader("X-User-Id"))
+	latestTreeSha, err := createNewTreeForCommit(ctx, projectName, userName, org, latestCommitTreeSha, content)
+	if err != nil {
+		return "", err
+	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Changes committed successfully",
-	})
+	newCommitSha, err := createNewCommit(ctx, projectName, userName, org, latestTreeSha, latestCommistSha, message, coAuthors...)
+	if err != nil {
+		return "", err
+	}
 
+	return newCommitSha, updateReferenceToNewCommit(ctx, projectName, userName, org, newCommitSha, branchName)
 }
 
 // function to get latest commit sha from github
@@ -240,6 +311,13 @@ func createNewTreeForCommit(ctx *gin.Context, repoName string, userName string,
 		}
 	}
 
+	return createTree(ctx, repoName, userName, org, latestTreeSha, blobContents)
+
+}
+
+// createTree creates a tree on top of the base tree from raw tree entries,
+// entries can point to existing blobs by sha or carry their content
+func createTree(ctx *gin.Context, repoName string, userName string, org string, latestTreeSha string, blobContents []interface{}) (string, error) {
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"base_tree": latestTreeSha,
 		"tree":      blobContents,
@@ -279,12 +357,26 @@ func createNewTreeForCommit(ctx *gin.Context, repoName string, userName string,
 
 }
 
-func createNewCommit(ctx *gin.Context, repoName string, userName string, org string, latestTreeSha string, lastCommitSha string, message string) (string, error) {
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"message": message,
+func createNewCommit(ctx *gin.Context, repoName string, userName string, org string, latestTreeSha string, lastCommitSha string, message string, coAuthors ...string) (string, error) {
+	return createCommit(ctx, repoName, userName, org, latestTreeSha, []string{lastCommitSha}, message, coAuthors...)
+}
+
+// createCommit creates a commit with any number of parents, two for merges.
+// The acting user is the author, coAuthors get a Co-authored-by trailer.
+func createCommit(ctx *gin.Context, repoName string, userName string, org string, latestTreeSha string, parents []string, message string, coAuthors ...string) (string, error) {
+	payload := map[string]interface{}{
+		"message": withCoAuthors(message, ctx.GetHeader("X-User-Id"), coAuthors),
 		"tree":    latestTreeSha,
-		"parents": []string{lastCommitSha},
-	})
+		"parents": parents,
+	}
+	setCommitIdentity(ctx, payload)
+
+	return postCommit(ctx, repoName, userName, org, payload)
+}
+
+// postCommit creates a commit from a git commits API payload
+func postCommit(ctx *gin.Context, repoName string, userName string, org string, payload map[string]interface{}) (string, error) {
+	requestBody, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
@@ -318,13 +410,19 @@ func createNewCommit(ctx *gin.Context, repoName string, userName string, org str
 }
 
 func updateReferenceToNewCommit(ctx *gin.Context, repoName string, userName string, org string, latestCommitSha string, branchName string) error {
+	return updateReference(ctx, repoName, userName, org, latestCommitSha, branchName, false)
+}
+
+// updateReference moves a branch, force allows moves that aren't fast-forwards
+func updateReference(ctx *gin.Context, repoName string, userName string, org string, latestCommitSha string, branchName string, force bool) error {
 
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs/heads/%s", userName, repoName, branchName)
 	if org != "" {
 		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs/heads/%s", org, repoName, branchName)
 	}
-	requestBody, err := json.Marshal(map[string]string{
-		"sha": latestCommitSha,
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"sha":   latestCommitSha,
+		"force": force,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
@@ -416,6 +514,15 @@ type Contents struct {
 	ChangedContent  string `json:"changedContent"`
 }
 
+// contentPaths returns the repository paths touched by a commit
+func contentPaths(content []Contents) []string {
+	paths := make([]string, 0, len(content))
+	for _, c := range content {
+		paths = append(paths, c.Path)
+	}
+	return paths
+}
+
 type TreeResponse struct {
 	Sha       string      `json:"sha"`
 	URL       string      `json:"url"`
@@ -439,6 +546,12 @@ func CommitEditingChanges(ctx *gin.Context) {
 		Message    string     `json:"message"`
 		BranchName string     `json:"branch_name"`
 		PR         bool       `json:"pr"`
+		Reviewers  []string   `json:"reviewers"`
+		Flush      bool       `json:"flush"`
+		// Optional, generated from the branch diff when empty
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Base        string `json:"base"`
 	}
 
 	userID := ctx.GetHeader("X-User-Id")
@@ -455,6 +568,10 @@ func CommitEditingChanges(ctx *gin.Context) {
 		return
 	}
 
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
 	// getting details from DB
 	var projectName, userName, org string
 
@@ -480,43 +597,61 @@ func CommitEditingChanges(ctx *gin.Context) {
 		return
 	}
 
-	latestCommistSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, body.BranchName)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	latestCommitTreeSha, err := getLatestTreeShaForCommit(ctx, projectName, userName, org, latestCommistSha)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
+	// Checked before committing, a branch gets one open review
+	if body.PR && !canRequestReview(ctx, projectId, userID, body.BranchName) {
 		return
 	}
 
-	latestTreeSha, err := createNewTreeForCommit(ctx, projectName, userName, org, latestCommitTreeSha, body.Content)
+	// A pull request has to see every queued save of the branch
+	queued, err := commitOrQueue(ctx, projectId, userID, userName, projectName, org, body.BranchName, body.Content, body.Message, body.Flush || body.PR)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	newCommitSha, err := createNewCommit(ctx, projectName, userName, org, latestTreeSha, latestCommistSha, body.Message)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
+	if body.PR {
+		if body.Base == "" {
+			body.Base = "main"
+		}
 
-	err = updateReferenceToNewCommit(ctx, projectName, userName, org, newCommitSha, body.BranchName)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
+		title, description, err := describePullRequest(ctx, projectId.String(), projectName, userName, org, body.Base, body.BranchName, body.Title, body.Description)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
 
-	if body.PR {
-		err := CreatePullRequest(ctx, userName, org, projectName, body.BranchName, body.BranchName)
+		pr, err := CreatePullRequest(ctx, userName, org, projectName, body.BranchName, body.Base, title, description)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
-		delete(EditingBranchesMappings, projectId.String()+userID)
+
+		reviewID, err := createReviewRequest(projectId, userID, body.BranchName, title, description, &pr, body.Reviewers)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Pull request opened but saving the review failed : " + err.Error(),
+			})
+			return
+		}
+
+		utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPROpened, utils.BranchData{
+			BranchName: body.BranchName,
+		})
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message":   "Changes committed successfully",
+			"review_id": reviewID,
+			"pr_url":    pr.HTMLURL,
+		})
+		return
+	}
+
+	if queued != nil {
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message":  "Changes queued",
+			"flush_at": queued,
+		})
+		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
@@ -533,8 +668,17 @@ type PullRequest struct {
 	Body  string `json:"body"`
 }
 
-// CreatePullRequest creates a pull request using the GitHub API.
-func CreatePullRequest(ctx *gin.Context, owner, org, repo, headBranch, title string) error {
+// PullRequestResponse is the part of the created pull request we keep
+type PullRequestResponse struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// CreatePullRequest creates a pull request using the GitHub API, base
+// defaults to main.
+func CreatePullRequest(ctx *gin.Context, owner, org, repo, headBranch, base, title, body string) (PullRequestResponse, error) {
+	var created PullRequestResponse
+
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls", owner, repo)
 
 	if org != "" {
@@ -545,24 +689,28 @@ func CreatePullRequest(ctx *gin.Context, owner, org, repo, headBranch, title str
 	pr := PullRequest{
 		Title: title,
 		Head:  headBranch,
-		Base:  "main",
+		Base:  base,
+		Body:  body,
+	}
+	if pr.Base == "" {
+		pr.Base = "main"
 	}
 
 	// Serialize the payload to JSON
 	payload, err := json.Marshal(pr)
 	if err != nil {
-		return fmt.Errorf("failed to serialize pull request payload: %w", err)
+		return created, fmt.Errorf("failed to serialize pull request payload: %w", err)
 	}
 
 	// Create an HTTP client and request
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return created, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	token, err := utils.GetAccessTokenFromBackend(ctx)
 	if err != nil {
-		return err
+		return created, err
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
@@ -572,17 +720,21 @@ func CreatePullRequest(ctx *gin.Context, owner, org, repo, headBranch, title str
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send HTTP request: %w", err)
+		return created, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Check for errors in the response
 	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("failed to create pull request: status code %d", resp.StatusCode)
+		return created, fmt.Errorf("failed to create pull request: status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return created, fmt.Errorf("failed to decode response body: %w", err)
 	}
 
 	fmt.Println("Pull request created successfully")
-	return nil
+	return created, nil
 }
 
 func SaveDrawings(ctx *gin.Context) {
@@ -632,4 +784,35 @@ func SaveDrawings(ctx *gin.Context) {
 		return
 	}
 
+	if !validDrawingName(body.Name) {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name")
+		return
+	}
+
+	if !json.Valid([]byte(body.Content)) {
+		ctx.JSON(http.StatusBadRequest, "Drawing content must be JSON")
+		return
+	}
+
+	if body.Message == "" {
+		body.Message = "Update drawing " + body.Name
+	}
+
+	sha, err := commitContents(ctx, projectName, userName, org, "main", []Contents{{
+		Type:           "drawing",
+		Path:           drawingPath(body.Name),
+		Name:           body.Name,
+		ChangedContent: body.Content,
+	}}, body.Message)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	recordCommitActivity(projectId.String(), userID, "main", sha, body.Message, nil)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Drawing saved successfully",
+		"sha":     sha,
+	})
 }
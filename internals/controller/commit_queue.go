@@ -0,0 +1,544 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Saves of a project with a commit batch window are collected per branch in
+// the commit_queue table and written as a single commit once the window is
+// over. Any instance can add to a batch or commit it, FlushDueCommits commits
+// the batches whose window is over, so queued saves survive a restart. A
+// batch stays queued until its commit is on GitHub, failed ones are tried
+// again a few times before they are left for their authors.
+
+// maxBatchWindow caps the window so a misconfigured project doesn't hold
+// saves for ever
+const maxBatchWindow = 10 * time.Minute
+
+// maxFlushAttempts is how many times a batch is committed before it is marked
+// failed, a deleted branch would fail every window otherwise
+const maxFlushAttempts = 5
+
+type queuedCommit struct {
+	projectID   string
+	branch      string
+	userID      string // Last user who saved, their token is used for the commit
+	userName    string
+	projectName string
+	org         string
+	contents    map[string]Contents
+	paths       []string // Order in which paths were first saved
+	messages    []string
+	users       []string
+	flushAt     time.Time
+	attempts    int // Failed commits of the batch
+}
+
+const queuedCommitColumns = `project_id::text, branch_name, user_id::text, user_name, project_name, org, contents, paths, messages, users, flush_at, attempts`
+
+func scanQueuedCommit(row pgx.Row) (*queuedCommit, error) {
+	batch := &queuedCommit{}
+	var contents []byte
+
+	err := row.Scan(&batch.projectID, &batch.branch, &batch.userID, &batch.userName, &batch.projectName,
+		&batch.org, &contents, &batch.paths, &batch.messages, &batch.users, &batch.flushAt, &batch.attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, &batch.contents); err != nil {
+		return nil, fmt.Errorf("invalid queued contents: %w", err)
+	}
+
+	return batch, nil
+}
+
+func (b *queuedCommit) hasUser(userID string) bool {
+	for _, id := range b.users {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// add puts saved contents in the batch. A later save of the same path
+// replaces the content but keeps the original content of the first one, so
+// the batch reads as one change.
+func (b *queuedCommit) add(content []Contents) {
+	for _, c := range content {
+		if previous, ok := b.contents[c.Path]; ok {
+			c.OriginalContent = previous.OriginalContent
+		} else {
+			b.paths = append(b.paths, c.Path)
+		}
+		b.contents[c.Path] = c
+	}
+}
+
+// saveQueuedCommit saves a batch a user added to, a failed batch is tried
+// again with the new saves
+func saveQueuedCommit(tx pgx.Tx, batch *queuedCommit) error {
+	contents, err := json.Marshal(batch.contents)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO commit_queue (project_id, branch_name, user_id, user_name, project_name, org, contents, paths, messages, users, flush_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (project_id, branch_name) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			user_name = EXCLUDED.user_name,
+			project_name = EXCLUDED.project_name,
+			org = EXCLUDED.org,
+			contents = EXCLUDED.contents,
+			paths = EXCLUDED.paths,
+			messages = EXCLUDED.messages,
+			users = EXCLUDED.users,
+			flush_at = EXCLUDED.flush_at,
+			failed_at = NULL
+	`, batch.projectID, batch.branch, batch.userID, batch.userName, batch.projectName, batch.org,
+		contents, batch.paths, batch.messages, batch.users, batch.flushAt)
+	return err
+}
+
+// lockQueuedCommit holds the batch of a branch until tx ends, saves and
+// commits of the branch take turns on every instance
+func lockQueuedCommit(tx pgx.Tx, projectID, branch string) error {
+	_, err := tx.Exec(context.Background(), `SELECT pg_advisory_xact_lock(hashtext($1))`, "commit_queue:"+projectID+":"+branch)
+	return err
+}
+
+// updateQueuedCommit runs update on the batch of the branch, nil when none is
+// queued, and saves it. Instances change a batch one at a time.
+func updateQueuedCommit(projectID, branch string, update func(batch *queuedCommit) *queuedCommit) (*queuedCommit, error) {
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := lockQueuedCommit(tx, projectID, branch); err != nil {
+		return nil, err
+	}
+
+	batch, err := scanQueuedCommit(tx.QueryRow(context.Background(), `
+		SELECT `+queuedCommitColumns+` FROM commit_queue WHERE project_id = $1 AND branch_name = $2
+	`, projectID, branch))
+	if err == pgx.ErrNoRows {
+		batch = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	batch = update(batch)
+
+	if err := saveQueuedCommit(tx, batch); err != nil {
+		return nil, err
+	}
+
+	return batch, tx.Commit(context.Background())
+}
+
+// getCommitBatchWindow returns how long saves of the project are collected,
+// zero means every save is committed right away
+func getCommitBatchWindow(projectID uuid.UUID) (time.Duration, error) {
+	var seconds int
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT commit_batch_window FROM projects WHERE id = $1
+	`, projectID).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	window := time.Duration(seconds) * time.Second
+	if window > maxBatchWindow {
+		window = maxBatchWindow
+	}
+
+	return window, nil
+}
+
+// backgroundContext is a context for the github helpers outside of a request,
+// they only read the user id from it to get the token
+func backgroundContext(userID string) *gin.Context {
+	req, _ := http.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-User-Id", userID)
+	return &gin.Context{Request: req}
+}
+
+// queueCommit adds saved contents to the batch of the branch and returns
+// when the batch will be committed
+func queueCommit(projectID, branch, userID, userName, projectName, org string, content []Contents, message string, window time.Duration) (time.Time, error) {
+	batch, err := updateQueuedCommit(projectID, branch, func(batch *queuedCommit) *queuedCommit {
+		if batch == nil {
+			batch = &queuedCommit{
+				projectID: projectID,
+				branch:    branch,
+				contents:  make(map[string]Contents),
+				flushAt:   time.Now().Add(window),
+			}
+		}
+
+		batch.userID = userID
+		batch.userName = userName
+		batch.projectName = projectName
+		batch.org = org
+		if !batch.hasUser(userID) {
+			batch.users = append(batch.users, userID)
+		}
+
+		batch.add(content)
+
+		if message != "" {
+			batch.messages = append(batch.messages, message)
+		}
+
+		return batch
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to queue commit: %w", err)
+	}
+
+	return batch.flushAt, nil
+}
+
+// flushQueuedCommit writes the queued saves of a branch as one commit, it
+// does nothing when nothing is queued. The batch stays locked while it is
+// committed and only leaves the queue once main or the branch points at the
+// commit, saves made meanwhile wait for it.
+func flushQueuedCommit(projectID, branch string) error {
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read commit queue: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := lockQueuedCommit(tx, projectID, branch); err != nil {
+		return fmt.Errorf("failed to lock commit queue: %w", err)
+	}
+
+	batch, err := scanQueuedCommit(tx.QueryRow(context.Background(), `
+		SELECT `+queuedCommitColumns+` FROM commit_queue
+		WHERE project_id = $1 AND branch_name = $2
+		FOR UPDATE SKIP LOCKED
+	`, projectID, branch))
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read commit queue: %w", err)
+	}
+
+	content := make([]Contents, 0, len(batch.paths))
+	for _, path := range batch.paths {
+		content = append(content, batch.contents[path])
+	}
+
+	message := batchMessage(batch.messages, len(content))
+
+	// The last saver commits, everyone else in the batch is a co-author
+	var coAuthors []string
+	for _, user := range batch.users {
+		if user != batch.userID {
+			coAuthors = append(coAuthors, user)
+		}
+	}
+	sort.Strings(coAuthors)
+
+	ctx := backgroundContext(batch.userID)
+	sha, err := commitContents(ctx, batch.projectName, batch.userName, batch.org, branch, content, message, coAuthors...)
+	if err != nil {
+		if failErr := failQueuedCommit(tx, batch, err); failErr != nil {
+			fmt.Println("Error saving failed commit of the queue:", failErr)
+		}
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		DELETE FROM commit_queue WHERE project_id = $1 AND branch_name = $2
+	`, projectID, branch)
+	if err == nil {
+		err = tx.Commit(context.Background())
+	}
+	if err != nil {
+		// The commit is made, the next flush commits the same content again
+		// which changes nothing
+		fmt.Println("Error clearing committed saves from the queue:", err)
+	}
+
+	recordCommitActivity(projectID, batch.userID, branch, sha, message, coAuthors)
+
+	utils.BroadcastProjectEvent(projectID, batch.userID, utils.EventPageSaved, utils.PageSavedData{
+		Branch:  branch,
+		Paths:   contentPaths(content),
+		Message: message,
+	})
+
+	return nil
+}
+
+// failQueuedCommit keeps a batch that couldn't be committed for a later try,
+// waiting longer after every failure. After maxFlushAttempts it is marked
+// failed and its authors are told, it is only tried again once they save.
+func failQueuedCommit(tx pgx.Tx, batch *queuedCommit, commitErr error) error {
+	batch.attempts++
+
+	failed := batch.attempts >= maxFlushAttempts
+	retryAt := time.Now().Add(time.Minute << min(batch.attempts-1, maxFlushAttempts-1))
+
+	_, err := tx.Exec(context.Background(), `
+		UPDATE commit_queue
+		SET attempts = $3, last_error = $4, flush_at = $5,
+			failed_at = CASE WHEN $6 THEN now() ELSE NULL END
+		WHERE project_id = $1 AND branch_name = $2
+	`, batch.projectID, batch.branch, batch.attempts, commitErr.Error(), retryAt, failed)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return err
+	}
+
+	if failed {
+		utils.BroadcastProjectEvent(batch.projectID, batch.userID, utils.EventCommitFailed, utils.CommitFailedData{
+			Branch: batch.branch,
+			Paths:  batch.paths,
+			Users:  batch.users,
+			Error:  commitErr.Error(),
+		})
+	}
+
+	return nil
+}
+
+// batchMessage combines the messages of the queued saves
+func batchMessage(messages []string, files int) string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, m := range messages {
+		for _, line := range strings.Split(m, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || seen[line] {
+				continue
+			}
+			seen[line] = true
+			unique = append(unique, line)
+		}
+	}
+
+	switch len(unique) {
+	case 0:
+		return fmt.Sprintf("Update %d files", files)
+	case 1:
+		return unique[0]
+	default:
+		return fmt.Sprintf("Update %d files\n\n- %s", files, strings.Join(unique, "\n- "))
+	}
+}
+
+// flushProjectCommits flushes every queued batch of the project, or only the
+// ones with saves of userID when it is set
+func flushProjectCommits(projectID, userID string) error {
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT branch_name FROM commit_queue
+		WHERE project_id = $1 AND ($2 = '' OR $2 = ANY(users))
+	`, projectID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to read commit queue: %w", err)
+	}
+
+	var branches []string
+	for rows.Next() {
+		var branch string
+		if err := rows.Scan(&branch); err == nil {
+			branches = append(branches, branch)
+		}
+	}
+	rows.Close()
+
+	var errs []string
+	for _, branch := range branches {
+		if err := flushQueuedCommit(projectID, branch); err != nil {
+			errs = append(errs, branch+": "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to commit queued saves: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// FlushDueCommits commits the batches whose window is over, whichever
+// instance queued them. Failed batches wait for a new save or a flush.
+func FlushDueCommits() {
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT project_id::text, branch_name FROM commit_queue WHERE flush_at <= now() AND failed_at IS NULL
+	`)
+	if err != nil {
+		fmt.Println("Error reading commit queue:", err)
+		return
+	}
+
+	type due struct{ projectID, branch string }
+	var batches []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.projectID, &d.branch); err == nil {
+			batches = append(batches, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range batches {
+		if err := flushQueuedCommit(d.projectID, d.branch); err != nil {
+			fmt.Printf("Error committing queued saves of %s on %s: %v\n", d.projectID, d.branch, err)
+		}
+	}
+}
+
+// FlushUserCommits commits the queued saves of a user who left the project
+func FlushUserCommits(projectID, userID string) {
+	if err := flushProjectCommits(projectID, userID); err != nil {
+		fmt.Println("Error flushing saves of user leaving the project:", err)
+	}
+}
+
+// FlushCommits commits the queued saves of a project right away, with
+// ?branch= only the ones of that branch
+func FlushCommits(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	if branch := ctx.Query("branch"); branch != "" {
+		err = flushQueuedCommit(projectId.String(), branch)
+	} else {
+		err = flushProjectCommits(projectId.String(), "")
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Queued changes committed"})
+}
+
+// GetQueuedCommits lists what is waiting to be committed for the project
+func GetQueuedCommits(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	type queued struct {
+		Branch   string     `json:"branch"`
+		Paths    []string   `json:"paths"`
+		FlushAt  time.Time  `json:"flush_at"`
+		Attempts int        `json:"attempts"`
+		Error    string     `json:"error,omitempty"` // Of the last failed commit
+		FailedAt *time.Time `json:"failed_at"`       // Set once the batch stopped being tried
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT branch_name, paths, flush_at, attempts, last_error, failed_at
+		FROM commit_queue WHERE project_id = $1 ORDER BY flush_at
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting commit queue from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	result := []queued{}
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.Branch, &q.Paths, &q.FlushAt, &q.Attempts, &q.Error, &q.FailedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning commit queue : " + err.Error(),
+			})
+			return
+		}
+		result = append(result, q)
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// SetCommitBatchWindow sets how many seconds saves are collected before they
+// are committed, 0 turns batching off
+func SetCommitBatchWindow(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+		Seconds   int    `json:"seconds"`
+	}
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	if body.Seconds < 0 || time.Duration(body.Seconds)*time.Second > maxBatchWindow {
+		ctx.JSON(http.StatusBadRequest, fmt.Sprintf("Window must be between 0 and %d seconds", int(maxBatchWindow.Seconds())))
+		return
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE projects SET commit_batch_window = $2 WHERE id = $1
+	`, projectId, body.Seconds)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating project : " + err.Error(),
+		})
+		return
+	}
+
+	// Turning batching off shouldn't leave saves waiting
+	if body.Seconds == 0 {
+		if err := flushProjectCommits(projectId.String(), ""); err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"seconds": body.Seconds})
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PublishDeploy is one publish of the docs that can be rolled back to
+type PublishDeploy struct {
+	ID            string    `json:"id"`
+	ParentID      *string   `json:"parent_id"`
+	JobID         *string   `json:"job_id"`
+	CreatedBy     *string   `json:"created_by"`
+	FileCount     int       `json:"file_count"`
+	UploadedCount int       `json:"uploaded_count"` // Files the deploy uploaded, the rest is reused
+	Current       bool      `json:"current"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ListDeploys lists the deploys of the public docs that are still kept
+func ListDeploys(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT d.id::text, d.parent_id::text, d.job_id::text, d.created_by::text, d.file_count, d.uploaded_count,
+			COALESCE(p.current_deploy_id = d.id, false), d.created_at
+		FROM publish_deploys d
+		JOIN projects p ON p.id = d.project_id
+		WHERE d.project_id = $1 AND NOT d.pruned
+		ORDER BY d.created_at DESC
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting deploys from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	deploys := []PublishDeploy{}
+	for rows.Next() {
+		var d PublishDeploy
+		if err := rows.Scan(&d.ID, &d.ParentID, &d.JobID, &d.CreatedBy, &d.FileCount, &d.UploadedCount, &d.Current, &d.CreatedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning deploys : " + err.Error(),
+			})
+			return
+		}
+		deploys = append(deploys, d)
+	}
+
+	ctx.JSON(http.StatusOK, deploys)
+}
+
+// RollbackDeploy points the public docs back at an earlier deploy
+func RollbackDeploy(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+		DeployID  string `json:"deploy_id"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	run, err := utils.QueuePublishJob(projectId.String(), userID, models.PublishRollback)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating publish job : " + err.Error(),
+		})
+		return
+	}
+
+	// Run as a job so it can't land between the upload and the switch of a
+	// publish, it is a single put so we wait for it
+	rolledBack := false
+	err = run.Run(func(run *utils.PublishRun) error {
+		if err := utils.RollbackDeploy(projectId.String(), body.DeployID); err != nil {
+			return err
+		}
+		rolledBack = true
+		return nil
+	})
+	if err == utils.ErrDeployNotFound {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error rolling back : " + err.Error(),
+		})
+		return
+	}
+	if !rolledBack {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "The docs were unpublished while rolling back"})
+		return
+	}
+
+	slug, err := publishedSlug(projectId)
+	if err == nil {
+		utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPublished, utils.PublishedData{
+			Slug: slug,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Rolled back", "deploy_id": body.DeployID})
+}
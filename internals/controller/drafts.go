@@ -0,0 +1,293 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SaveDraft stores the working copies of the pages the user is editing, it
+// is called by the editor's autosave so a crashed tab doesn't lose work
+func SaveDraft(ctx *gin.Context) {
+	var body struct {
+		ProjectID string     `json:"project_id"`
+		Content   []Contents `json:"content"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	if len(body.Content) == 0 {
+		ctx.JSON(http.StatusBadRequest, "Nothing to save")
+		return
+	}
+
+	for _, c := range body.Content {
+		if c.Path == "" {
+			ctx.JSON(http.StatusBadRequest, "Every draft needs a path")
+			return
+		}
+	}
+
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to create transaction: " + err.Error(),
+		})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	for _, c := range body.Content {
+		// The original content is kept from the first save so the draft can
+		// still be compared with what the user started from
+		_, err = tx.Exec(context.Background(), `
+			INSERT INTO drafts (user_id, project_id, path, page_id, name, type, original_content, changed_content)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (user_id, project_id, path) DO UPDATE SET
+				page_id = EXCLUDED.page_id,
+				name = EXCLUDED.name,
+				type = EXCLUDED.type,
+				changed_content = EXCLUDED.changed_content,
+				updated_at = now()
+		`, userID, projectId, c.Path, c.Id, c.Name, c.Type, c.OriginalContent, c.ChangedContent)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error saving draft : " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving draft : " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Draft saved successfully",
+	})
+}
+
+// GetDrafts lists the drafts of the user for a project, the editor loads
+// them when the project is opened
+func GetDrafts(ctx *gin.Context) {
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	drafts, err := getDrafts(projectId, userID, nil)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting drafts from DB : " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, drafts)
+}
+
+// DiscardDraft deletes the draft of a single page
+func DiscardDraft(ctx *gin.Context) {
+	userID := ctx.GetHeader("X-User-Id")
+	path := ctx.Query("path")
+
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	if path == "" {
+		ctx.JSON(http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	result, err := initializer.DB.Exec(context.Background(), `DELETE FROM drafts WHERE user_id = $1 AND project_id = $2 AND path = $3`, userID, projectId, path)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting draft : " + err.Error(),
+		})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		ctx.JSON(http.StatusNotFound, "Draft not found")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, "Draft discarded successfully")
+}
+
+// CommitDrafts commits the drafts of the user in a single commit to one of
+// their editing branches and clears them. Only the given paths are committed
+// when paths is set.
+func CommitDrafts(ctx *gin.Context) {
+	var body struct {
+		ProjectID  string   `json:"project_id"`
+		Message    string   `json:"message"`
+		BranchName string   `json:"branch_name"`
+		Paths      []string `json:"paths"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	// Drafts go to the user's own branch, main and other users' branches
+	// are changed through reviews
+	active, err := activeBranchOf(projectId, userID, body.BranchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return
+	}
+	if !active {
+		ctx.JSON(http.StatusForbidden, "You have no active branch named "+body.BranchName)
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	drafts, err := getDrafts(projectId, userID, body.Paths)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting drafts from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if len(drafts) == 0 {
+		ctx.JSON(http.StatusBadRequest, "No drafts to commit")
+		return
+	}
+
+	content := make([]Contents, 0, len(drafts))
+	for _, d := range drafts {
+		content = append(content, Contents{
+			Type:            d.Type,
+			Path:            d.Path,
+			Name:            d.Name,
+			Id:              d.PageID,
+			OriginalContent: d.OriginalContent,
+			ChangedContent:  d.ChangedContent,
+		})
+	}
+
+	if body.Message == "" {
+		body.Message = fmt.Sprintf("Update %d page(s)", len(drafts))
+	}
+
+	sha, err := commitContents(ctx, projectName, userName, org, body.BranchName, content, body.Message)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	recordCommitActivity(projectId.String(), userID, body.BranchName, sha, body.Message, nil)
+
+	// Drafts autosaved again while committing are newer than the commit, keep them
+	for _, d := range drafts {
+		_, err := initializer.DB.Exec(context.Background(), `DELETE FROM drafts WHERE id = $1 AND updated_at = $2`, d.ID, d.UpdatedAt)
+		if err != nil {
+			fmt.Println("Error clearing committed draft:", err)
+		}
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPageSaved, utils.PageSavedData{
+		Branch:  body.BranchName,
+		Paths:   contentPaths(content),
+		Message: body.Message,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":   "Changes committed successfully",
+		"committed": len(drafts),
+	})
+}
+
+// getDrafts returns the drafts of a user for a project, limited to paths when
+// any are given
+func getDrafts(projectID uuid.UUID, userID string, paths []string) ([]models.Draft, error) {
+	if paths == nil {
+		paths = []string{} // A nil slice is sent as NULL
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT id, path, page_id, name, type, original_content, changed_content, created_at, updated_at
+		FROM drafts
+		WHERE user_id = $1 AND project_id = $2 AND (cardinality($3::text[]) = 0 OR path = ANY($3))
+		ORDER BY updated_at DESC
+	`, userID, projectID, paths)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []models.Draft{}
+	for rows.Next() {
+		var d models.Draft
+		if err := rows.Scan(&d.ID, &d.Path, &d.PageID, &d.Name, &d.Type, &d.OriginalContent, &d.ChangedContent, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+
+	return drafts, rows.Err()
+}
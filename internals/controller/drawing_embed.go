@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// A page embeds a drawing with a block like
+// {"type": "drawing", "props": {"project_id": "<drawings project>", "name": "<drawing>"}}
+// anywhere in its content.
+
+// DrawingRef points to a drawing of a drawings project
+type DrawingRef struct {
+	ProjectID string `json:"project_id"`
+	Name      string `json:"name"`
+}
+
+// findDrawingRefs walks the content of a page and returns every drawing it
+// embeds, each one once
+func findDrawingRefs(content []byte) []DrawingRef {
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil
+	}
+
+	refs := []DrawingRef{}
+	seen := map[DrawingRef]bool{}
+
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch n := node.(type) {
+		case []interface{}:
+			for _, child := range n {
+				walk(child)
+			}
+		case map[string]interface{}:
+			if n["type"] == "drawing" {
+				if props, ok := n["props"].(map[string]interface{}); ok {
+					projectID, _ := props["project_id"].(string)
+					name, _ := props["name"].(string)
+					ref := DrawingRef{ProjectID: projectID, Name: name}
+
+					if _, err := uuid.Parse(projectID); err == nil && validDrawingName(name) && !seen[ref] {
+						seen[ref] = true
+						refs = append(refs, ref)
+					}
+				}
+			}
+			for _, child := range n {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+
+	return refs
+}
+
+// canEmbedDrawing tells if a page of the project may show the drawing. The
+// drawings project has to belong to the owner of the docs, or the user has to
+// be a member of it, so a page can't be used to read someone else's drawings.
+func canEmbedDrawing(pageProjectID uuid.UUID, ref DrawingRef, userID string) bool {
+	var allowed bool
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (
+			SELECT 1 FROM projects d, projects p
+			WHERE d.id = $1 AND d.type = 'drawings' AND p.id = $2
+				AND (d.owner = p.owner OR EXISTS (
+					SELECT 1 FROM user_project_mapping upm
+					WHERE upm.project_id = d.id AND upm.user_id::text = $3
+				))
+		)
+	`, ref.ProjectID, pageProjectID, userID).Scan(&allowed)
+	if err != nil {
+		fmt.Println("Error checking drawing access:", err)
+		return false
+	}
+
+	return allowed
+}
+
+// fetchEmbeddedDrawing reads a drawing with the token of the owner of its
+// project, viewers of the page don't need access to the drawings repo
+func fetchEmbeddedDrawing(ref DrawingRef) ([]byte, error) {
+	var ownerID, repoOwner, projectName, org string
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT owner, COALESCE(repo_owner, ''), name, COALESCE(org, '')
+		FROM projects WHERE id = $1 AND type = 'drawings'
+	`, ref.ProjectID).Scan(&ownerID, &repoOwner, &projectName, &org)
+	if err != nil {
+		return nil, fmt.Errorf("drawings project not found: %w", err)
+	}
+
+	encoded, err := getDrawingJson(backgroundContext(ownerID), projectName, repoOwner, org, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// embeddedDrawings returns the drawings embedded in the given pages that
+// can be shown, keyed by the path they are published under
+func embeddedDrawings(pages []FileContent, projectID uuid.UUID, userID string) []FileContent {
+	var drawings []FileContent
+	seen := map[DrawingRef]bool{}
+
+	for _, page := range pages {
+		content, err := base64.StdEncoding.DecodeString(page.Content)
+		if err != nil {
+			continue
+		}
+
+		for _, ref := range findDrawingRefs(content) {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+
+			if !canEmbedDrawing(projectID, ref, userID) {
+				fmt.Printf("Skipping drawing %s/%s embedded without access\n", ref.ProjectID, ref.Name)
+				continue
+			}
+
+			data, err := fetchEmbeddedDrawing(ref)
+			if err != nil {
+				fmt.Printf("Error fetching embedded drawing %s/%s: %v\n", ref.ProjectID, ref.Name, err)
+				continue
+			}
+
+			drawings = append(drawings, FileContent{
+				Path:    publicDrawingPath(ref.ProjectID, ref.Name),
+				Content: string(data),
+			})
+		}
+	}
+
+	return drawings
+}
+
+// EmbeddedDrawing is a drawing resolved for a page, Error is set instead of
+// Content when it can't be shown
+type EmbeddedDrawing struct {
+	DrawingRef
+	Content json.RawMessage `json:"content,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// GetEmbeddedDrawings resolves the drawings embedded in a page for a member of
+// its project, ?ref= reads the page from a branch
+func GetEmbeddedDrawings(ctx *gin.Context) {
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Query("proj"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	fileID, err := uuid.Parse(ctx.Query("file"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing file id "+err.Error())
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	encoded, err := getFileContentFromGithub(ctx, projectName, userName, fileID, org, "github", ctx.Query("ref"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error decoding page "+err.Error())
+		return
+	}
+
+	drawings := []EmbeddedDrawing{}
+	for _, ref := range findDrawingRefs(content) {
+		drawing := EmbeddedDrawing{DrawingRef: ref}
+
+		if !canEmbedDrawing(projectId, ref, userID) {
+			drawing.Error = "You don't have access to this drawing"
+			drawings = append(drawings, drawing)
+			continue
+		}
+
+		data, err := fetchEmbeddedDrawing(ref)
+		if err != nil || !json.Valid(data) {
+			drawing.Error = "Drawing not found"
+		} else {
+			drawing.Content = data
+		}
+		drawings = append(drawings, drawing)
+	}
+
+	ctx.JSON(http.StatusOK, drawings)
+}
+
+// SyncedDrawings returns the drawings embedded in the pages of a webhook sync,
+// it runs without a user so only drawings of the docs owner are embedded
+func SyncedDrawings(projectID string, pages []FileContent) []FileContent {
+	projectId, err := uuid.Parse(projectID)
+	if err != nil {
+		return nil
+	}
+	return embeddedDrawings(pages, projectId, "")
+}
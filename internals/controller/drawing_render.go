@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// publicDrawingPath is where a published drawing is kept in the docs that
+// embed it
+func publicDrawingPath(projectID, name string) string {
+	return "drawings/" + projectID + "/" + name + ".json"
+}
+
+// renderFormat reads ?format=, svg unless png is asked for
+func renderFormat(ctx *gin.Context) (string, bool) {
+	switch ctx.DefaultQuery("format", "svg") {
+	case "svg":
+		return "svg", true
+	case "png":
+		return "png", true
+	}
+	return "", false
+}
+
+// serveDrawingRender writes the render of a drawing, the blob sha doubles as
+// the ETag as the same blob always renders the same
+func serveDrawingRender(ctx *gin.Context, content []byte, format, cacheControl string) {
+	blobSha := utils.GitBlobSha(content)
+	etag := `"` + blobSha + "-" + format + `"`
+
+	ctx.Header("ETag", etag)
+	ctx.Header("Cache-Control", cacheControl)
+	if ctx.GetHeader("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	rendered, err := utils.RenderDrawingCached(blobSha, format, content)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "Error rendering drawing : " + err.Error(),
+		})
+		return
+	}
+
+	contentType := "image/svg+xml"
+	if format == "png" {
+		contentType = "image/png"
+	}
+
+	ctx.Data(http.StatusOK, contentType, rendered)
+}
+
+// RenderDrawing renders the current version of a drawing as SVG, or PNG
+// with ?format=png
+func RenderDrawing(ctx *gin.Context) {
+	name := ctx.Param("name")
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Query("proj"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	format, ok := renderFormat(ctx)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, "Format should be svg or png")
+		return
+	}
+
+	if !validDrawingName(name) {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	encoded, err := getDrawingJson(ctx, projectName, userName, org, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error decoding drawing "+err.Error())
+		return
+	}
+
+	serveDrawingRender(ctx, content, format, "private, no-cache")
+}
+
+// RenderPublicDrawing renders a drawing published along with the docs
+func RenderPublicDrawing(ctx *gin.Context) {
+	renderPublishedDrawing(ctx, func(path string) ([]byte, error) {
+		return utils.ReadPublishedFile(ctx.Param("name"), path)
+	}, "public, max-age=300")
+}
+
+// RenderPreviewDrawing renders a drawing of a preview
+func RenderPreviewDrawing(ctx *gin.Context) {
+	ctx.Header("X-Robots-Tag", "noindex")
+	renderPublishedDrawing(ctx, func(path string) ([]byte, error) {
+		return utils.ReadPreviewFile(ctx.Param("name"), ctx.Param("token"), path)
+	}, "private, no-store")
+}
+
+func renderPublishedDrawing(ctx *gin.Context, read func(path string) ([]byte, error), cacheControl string) {
+	name := ctx.Param("drawing")
+
+	format, ok := renderFormat(ctx)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, "Format should be svg or png")
+		return
+	}
+
+	if _, err := uuid.Parse(ctx.Param("project")); err != nil || !validDrawingName(name) {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
+
+	content, err := read(publicDrawingPath(ctx.Param("project"), name))
+	if err == utils.ErrPublicObjectNotFound {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Failed to read object data")
+		return
+	}
+
+	serveDrawingRender(ctx, content, format, cacheControl)
+}
@@ -0,0 +1,420 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// A drawing is a folder at the root of a drawings repo holding <name>/<name>.json
+
+func drawingPath(name string) string {
+	return name + "/" + name + ".json"
+}
+
+// validDrawingName keeps drawing names to a single path segment
+func validDrawingName(name string) bool {
+	return name != "" && len(name) <= 100 &&
+		!strings.ContainsAny(name, "/\\") &&
+		!strings.HasPrefix(name, ".") &&
+		name != "Documentthing"
+}
+
+// drawingHead returns the head commit of main and the blobs of its tree
+func drawingHead(ctx *gin.Context, projectName, userName, org string) (string, string, map[string]string, error) {
+	commitSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, "main")
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	treeSha, err := getLatestTreeShaForCommit(ctx, projectName, userName, org, commitSha)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	blobs, err := getRecursiveTree(ctx, projectName, userName, org, treeSha)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return commitSha, treeSha, blobs, nil
+}
+
+// drawingFolderEntries copies the folder of a drawing to a new name, the
+// scene file is renamed along with the folder. With remove the old paths are
+// deleted, which makes it a rename.
+func drawingFolderEntries(blobs map[string]string, name, newName string, remove bool) []interface{} {
+	var entries []interface{}
+
+	for path, sha := range blobs {
+		if !strings.HasPrefix(path, name+"/") {
+			continue
+		}
+
+		rest := strings.TrimPrefix(path, name+"/")
+		if rest == name+".json" {
+			rest = newName + ".json"
+		}
+
+		blob := sha
+		entries = append(entries, TreeShaEntry{Path: newName + "/" + rest, Mode: "100644", Type: "blob", Sha: &blob})
+
+		if remove {
+			entries = append(entries, TreeShaEntry{Path: path, Mode: "100644", Type: "blob"})
+		}
+	}
+
+	return entries
+}
+
+// commitDrawingEntries commits tree entries on top of main
+func commitDrawingEntries(ctx *gin.Context, projectId uuid.UUID, projectName, userName, org, headSha, treeSha string, entries []interface{}, message string) (string, error) {
+	newTree, err := createTree(ctx, projectName, userName, org, treeSha, entries)
+	if err != nil {
+		return "", err
+	}
+
+	commitSha, err := createNewCommit(ctx, projectName, userName, org, newTree, headSha, message)
+	if err != nil {
+		return "", err
+	}
+
+	if err := updateReferenceToNewCommit(ctx, projectName, userName, org, commitSha, "main"); err != nil {
+		return "", err
+	}
+
+	recordCommitActivity(projectId.String(), ctx.GetHeader("X-User-Id"), "main", commitSha, message, nil)
+
+	return commitSha, nil
+}
+
+// copyDrawing renames or duplicates a drawing folder
+func copyDrawing(ctx *gin.Context, remove bool) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+		Name      string `json:"name"`
+		NewName   string `json:"new_name"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !validDrawingName(body.Name) || !validDrawingName(body.NewName) || body.Name == body.NewName {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	headSha, treeSha, blobs, err := drawingHead(ctx, projectName, userName, org)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, exists := blobs[drawingPath(body.Name)]; !exists {
+		ctx.JSON(http.StatusNotFound, "Drawing not found")
+		return
+	}
+
+	for path := range blobs {
+		if strings.HasPrefix(path, body.NewName+"/") {
+			ctx.JSON(http.StatusConflict, "A drawing named "+body.NewName+" already exists")
+			return
+		}
+	}
+
+	message := fmt.Sprintf("Duplicate drawing %s as %s", body.Name, body.NewName)
+	if remove {
+		message = fmt.Sprintf("Rename drawing %s to %s", body.Name, body.NewName)
+	}
+
+	sha, err := commitDrawingEntries(ctx, projectId, projectName, userName, org, headSha, treeSha, drawingFolderEntries(blobs, body.Name, body.NewName, remove), message)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"name": body.NewName,
+		"sha":  sha,
+	})
+}
+
+// RenameDrawing renames a drawing folder along with its scene file
+func RenameDrawing(ctx *gin.Context) {
+	copyDrawing(ctx, true)
+}
+
+// DuplicateDrawing copies a drawing under a new name
+func DuplicateDrawing(ctx *gin.Context) {
+	copyDrawing(ctx, false)
+}
+
+// DeleteDrawing deletes a drawing folder, ?proj= is the drawings project
+func DeleteDrawing(ctx *gin.Context) {
+	name := ctx.Param("name")
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Query("proj"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !validDrawingName(name) {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	headSha, treeSha, blobs, err := drawingHead(ctx, projectName, userName, org)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var entries []interface{}
+	for path := range blobs {
+		if strings.HasPrefix(path, name+"/") {
+			entries = append(entries, TreeShaEntry{Path: path, Mode: "100644", Type: "blob"})
+		}
+	}
+
+	if len(entries) == 0 {
+		ctx.JSON(http.StatusNotFound, "Drawing not found")
+		return
+	}
+
+	sha, err := commitDrawingEntries(ctx, projectId, projectName, userName, org, headSha, treeSha, entries, "Delete drawing "+name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sha": sha})
+}
+
+// DrawingVersion is a commit that changed a drawing
+type DrawingVersion struct {
+	Sha     string    `json:"sha"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+}
+
+// GetDrawingHistory lists the commits that changed a drawing, newest first
+func GetDrawingHistory(ctx *gin.Context) {
+	name := ctx.Param("name")
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Query("proj"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !validDrawingName(name) {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	owner := userName
+	if org != "" {
+		owner = org
+	}
+
+	var commits []struct {
+		Sha    string `json:"sha"`
+		Commit struct {
+			Message string `json:"message"`
+			Author  struct {
+				Name string    `json:"name"`
+				Date time.Time `json:"date"`
+			} `json:"author"`
+		} `json:"commit"`
+	}
+
+	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits?sha=main&path=%s&per_page=100",
+		owner, projectName, url.QueryEscape(drawingPath(name)))
+	if err := githubGet(ctx, apiURL, &commits); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	versions := []DrawingVersion{}
+	for _, c := range commits {
+		versions = append(versions, DrawingVersion{
+			Sha:     c.Sha,
+			Message: c.Commit.Message,
+			Author:  c.Commit.Author.Name,
+			Date:    c.Commit.Author.Date,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, versions)
+}
+
+// getDrawingAt returns the scene of a drawing as it was at a commit
+func getDrawingAt(ctx *gin.Context, projectName, userName, org, name, commitSha string) ([]byte, error) {
+	treeSha, err := getLatestTreeShaForCommit(ctx, projectName, userName, org, commitSha)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := getRecursiveTree(ctx, projectName, userName, org, treeSha)
+	if err != nil {
+		return nil, err
+	}
+
+	blobSha, exists := blobs[drawingPath(name)]
+	if !exists {
+		return nil, nil
+	}
+
+	return getBlobContent(ctx, projectName, userName, org, blobSha)
+}
+
+// GetDrawingVersion returns the scene of a drawing at a commit of its history
+func GetDrawingVersion(ctx *gin.Context) {
+	name := ctx.Param("name")
+	userID := ctx.GetHeader("X-User-Id")
+
+	projectId, err := uuid.Parse(ctx.Query("proj"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !validDrawingName(name) {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	content, err := getDrawingAt(ctx, projectName, userName, org, name, ctx.Param("sha"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if content == nil {
+		ctx.JSON(http.StatusNotFound, "Drawing didn't exist at this version")
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/json", content)
+}
+
+// RestoreDrawing makes a version from the history the current drawing, as a
+// new commit so the history is kept
+func RestoreDrawing(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+		Name      string `json:"name"`
+		Sha       string `json:"sha"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !validDrawingName(body.Name) || body.Sha == "" {
+		ctx.JSON(http.StatusBadRequest, "Invalid drawing name or version")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	content, err := getDrawingAt(ctx, projectName, userName, org, body.Name, body.Sha)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if content == nil {
+		ctx.JSON(http.StatusNotFound, "Drawing didn't exist at this version")
+		return
+	}
+
+	short := body.Sha
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	message := fmt.Sprintf("Restore drawing %s to %s", body.Name, short)
+
+	sha, err := commitContents(ctx, projectName, userName, org, "main", []Contents{{
+		Type:           "drawing",
+		Path:           drawingPath(body.Name),
+		Name:           body.Name,
+		ChangedContent: string(content),
+	}}, message)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	recordCommitActivity(projectId.String(), userID, "main", sha, message, nil)
+
+	ctx.JSON(http.StatusOK, gin.H{"sha": sha})
+}
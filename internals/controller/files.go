@@ -186,7 +186,42 @@ func UpdateFileContents(ctx *gin.Context) {
 		return
 	}
 
-	err = saveContentIntoGithubFiles(ctx, fileID, projectName, userName, body.Content, org)
+	window, err := getCommitBatchWindow(projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// With batching on the save joins the commit queue of main instead of
+	// being its own commit
+	if window > 0 {
+		content, err := base64.StdEncoding.DecodeString(body.Content)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, "Error while decoding content "+err.Error())
+			return
+		}
+
+		flushAt, err := queueCommit(projectId.String(), "main", ctx.GetHeader("X-User-Id"), userName, projectName, org, []Contents{{
+			Type:           "file",
+			Path:           fmt.Sprintf("Documentthing/files/%s.json", fileID),
+			Id:             fileID.String(),
+			ChangedContent: string(content),
+		}}, "updated file content", window)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message":  "Data queued",
+			"flush_at": flushAt,
+		})
+		return
+	}
+
+	err = saveContentIntoGithubFiles(ctx, projectId.String(), "main", fileID, projectName, userName, body.Content, org)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, err.Error())
 		return
@@ -196,19 +231,25 @@ func UpdateFileContents(ctx *gin.Context) {
 
 }
 
-func saveContentIntoGithubFiles(ctx *gin.Context, fileID uuid.UUID, repoName string, repoAdmin string, content string, org string) error {
+func saveContentIntoGithubFiles(ctx *gin.Context, projectID, branch string, fileID uuid.UUID, repoName string, repoAdmin string, content string, org string) error {
 
-	sha, err := getFileSha(ctx, repoName, repoAdmin, fileID, org)
+	sha, err := getFileSha(ctx, repoName, repoAdmin, fileID, org, branch)
 	if err != nil {
 		return fmt.Errorf("failed to get sha of file: %w", err)
 	}
 
 	// Prepare the request body for GitHub API
-	requestBody, err := json.Marshal(map[string]interface{}{
+	payload := map[string]interface{}{
 		"message": "updated file content",
 		"content": content, // Base64-encoded empty string for folder creation
 		"sha":     sha,
-	})
+	}
+	if branch != "" {
+		payload["branch"] = branch
+	}
+	setCommitIdentity(ctx, payload)
+
+	requestBody, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -246,10 +287,12 @@ func saveContentIntoGithubFiles(ctx *gin.Context, fileID uuid.UUID, repoName str
 		return fmt.Errorf("failed to get repository: %s", resp.Status)
 	}
 
+	recordContentsCommit(ctx, projectID, branch, resp)
+
 	return nil
 }
 
-func getFileSha(ctx *gin.Context, repoName string, userName string, fileID uuid.UUID, org string) (string, error) {
+func getFileSha(ctx *gin.Context, repoName string, userName string, fileID uuid.UUID, org, branch string) (string, error) {
 
 	// Create a new HTTP request to GitHub API
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/Documentthing/files/%s.json", userName, repoName, fileID)
@@ -258,6 +301,11 @@ func getFileSha(ctx *gin.Context, repoName string, userName string, fileID uuid.
 		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/Documentthing/files/%s.json", org, repoName, fileID)
 	}
 
+	// Read the sha on the branch the write goes to
+	if branch != "" {
+		url += "?ref=" + branch
+	}
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create new HTTP request: %w", err)
@@ -366,7 +414,7 @@ func DeleteFiles(ctx *gin.Context) {
 		return
 	}
 
-	updatedFolder, err := deleteFolderContents(ctx, folder, projectName, userName, fileID, orgName)
+	updatedFolder, err := deleteFolderContents(ctx, projectId.String(), "main", folder, projectName, userName, fileID, orgName)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Error While deleting files : " + err.Error(),
@@ -391,7 +439,7 @@ func DeleteFiles(ctx *gin.Context) {
 	// Step 2: Encode the JSON string to Base64
 	base64String := base64.StdEncoding.EncodeToString(jsonBytes)
 
-	err = updateFolderStructure(ctx, userName, projectName, base64String, orgName)
+	err = updateFolderStructure(ctx, projectId.String(), "main", userName, projectName, base64String, orgName)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, err)
 		return
@@ -401,9 +449,9 @@ func DeleteFiles(ctx *gin.Context) {
 
 }
 
-func deleteFolderContents(ctx *gin.Context, folders []models.Folder, repoName string, repoOwner string, fileID uuid.UUID, org string) ([]models.Folder, error) {
+func deleteFolderContents(ctx *gin.Context, projectID, branch string, folders []models.Folder, repoName string, repoOwner string, fileID uuid.UUID, org string) ([]models.Folder, error) {
 
-	err := recusrsive(ctx, folders, repoName, repoOwner, fileID, org)
+	err := recusrsive(ctx, projectID, branch, folders, repoName, repoOwner, fileID, org)
 	if err != nil {
 		return nil, err
 	}
@@ -443,22 +491,22 @@ func removeDeletedFolder(folders []models.Folder, fileID uuid.UUID) ([]models.Fo
 	return updatedFolder, nil
 }
 
-func recusrsive(ctx *gin.Context, folders []models.Folder, repoName string, repoOwner string, fileID uuid.UUID, org string) error {
+func recusrsive(ctx *gin.Context, projectID, branch string, folders []models.Folder, repoName string, repoOwner string, fileID uuid.UUID, org string) error {
 	for _, folder := range folders {
 		if len(folder.Children) > 0 {
-			err := recusrsive(ctx, folder.Children, repoName, repoOwner, fileID, org)
+			err := recusrsive(ctx, projectID, branch, folder.Children, repoName, repoOwner, fileID, org)
 			if err != nil {
 				return fmt.Errorf("failed to delete child files: %w", err)
 			}
 		}
 		if folder.ID == fileID {
 			if len(folder.Children) > 0 {
-				err := recDeleteFile(ctx, folder.Children, repoName, repoOwner, org)
+				err := recDeleteFile(ctx, projectID, branch, folder.Children, repoName, repoOwner, org)
 				if err != nil {
 					return fmt.Errorf("failed to delete child files: %w", err)
 				}
 			}
-			if err := deleteFileFromGithub(ctx, repoName, repoOwner, fileID, org); err != nil {
+			if err := deleteFileFromGithub(ctx, projectID, branch, repoName, repoOwner, fileID, org); err != nil {
 				return err
 			}
 		}
@@ -467,12 +515,12 @@ func recusrsive(ctx *gin.Context, folders []models.Folder, repoName string, repo
 	return nil
 }
 
-func recDeleteFile(ctx *gin.Context, folders []models.Folder, repoName string, repoOwner string, org string) error {
+func recDeleteFile(ctx *gin.Context, projectID, branch string, folders []models.Folder, repoName string, repoOwner string, org string) error {
 	for _, folder := range folders {
 		if len(folder.Children) > 0 {
-			recDeleteFile(ctx, folder.Children, repoName, repoOwner, org)
+			recDeleteFile(ctx, projectID, branch, folder.Children, repoName, repoOwner, org)
 		}
-		err := deleteFileFromGithub(ctx, repoName, repoOwner, folder.ID, org)
+		err := deleteFileFromGithub(ctx, projectID, branch, repoName, repoOwner, folder.ID, org)
 		if err != nil {
 			return err
 		}
@@ -480,17 +528,23 @@ func recDeleteFile(ctx *gin.Context, folders []models.Folder, repoName string, r
 	return nil
 }
 
-func deleteFileFromGithub(ctx *gin.Context, repoName string, repoOwner string, fileID uuid.UUID, org string) error {
-	sha, err := getFileSha(ctx, repoName, repoOwner, fileID, org)
+func deleteFileFromGithub(ctx *gin.Context, projectID, branch string, repoName string, repoOwner string, fileID uuid.UUID, org string) error {
+	sha, err := getFileSha(ctx, repoName, repoOwner, fileID, org, branch)
 	if err != nil {
 		return fmt.Errorf("failed to get sha of file: %w", err)
 	}
 
 	// Prepare the request body for GitHub API
-	requestBody, err := json.Marshal(map[string]interface{}{
+	payload := map[string]interface{}{
 		"message": "deletes file content",
 		"sha":     sha,
-	})
+	}
+	if branch != "" {
+		payload["branch"] = branch
+	}
+	setCommitIdentity(ctx, payload)
+
+	requestBody, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -528,6 +582,8 @@ func deleteFileFromGithub(ctx *gin.Context, repoName string, repoOwner string, f
 		return fmt.Errorf("failed to get repository: %s", resp.Status)
 	}
 
+	recordContentsCommit(ctx, projectID, branch, resp)
+
 	return nil
 }
 
@@ -626,7 +682,7 @@ func UpdateFileName(ctx *gin.Context) {
 	// Step 2: Encode the JSON string to Base64
 	base64String := base64.StdEncoding.EncodeToString(jsonBytes)
 
-	err = updateFolderStructure(ctx, userName, projectName, base64String, orgName)
+	err = updateFolderStructure(ctx, projectId.String(), "main", userName, projectName, base64String, orgName)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, err)
 		return
@@ -253,21 +253,61 @@ func UpdateFolder(ctx *gin.Context) {
 	base64String := base64.StdEncoding.EncodeToString(jsonBytes)
 
 	// Update folder structure on GitHub
-	if err := updateFolderStructure(ctx, userName, repoName, base64String, org); err != nil {
+	if err := updateFolderStructure(ctx, projectID.String(), "main", userName, repoName, base64String, org); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error updating folder structure on GitHub: " + err.Error()})
 		return
 	}
 
 	// Create a new file
-	if err := createFile(ctx, userName, repoName, body.Folder.ID.String(), org); err != nil {
+	if err := createFile(ctx, projectID.String(), "main", userName, repoName, body.Folder.ID.String(), org); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating file on GitHub: " + err.Error()})
 		return
 	}
 
+	utils.BroadcastProjectEvent(projectID.String(), ctx.GetHeader("X-User-Id"), utils.EventFolderChanged, utils.FolderChangedData{
+		Branch:   "main",
+		ParentID: body.ParentID,
+		Folder:   body.Folder,
+	})
+
 	// Respond with the updated folder structure
 	ctx.JSON(http.StatusCreated, folders)
 }
 
+// getFolderTree returns the decoded folder.json of a branch
+func getFolderTree(ctx *gin.Context, repoName, userName, org, branchName string) ([]models.Folder, error) {
+	folderBase64, err := getFolderJsonFromGithub(ctx, repoName, userName, org, "", branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonBytes, err := base64.StdEncoding.DecodeString(folderBase64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode folder structure: %w", err)
+	}
+
+	var folders []models.Folder
+	if err := json.Unmarshal(jsonBytes, &folders); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal folder structure: %w", err)
+	}
+
+	return folders, nil
+}
+
+// folderTitles maps every page id in the tree to its title
+func folderTitles(folders []models.Folder, titles map[string]string) map[string]string {
+	if titles == nil {
+		titles = make(map[string]string)
+	}
+
+	for _, folder := range folders {
+		titles[folder.ID.String()] = folder.Name
+		folderTitles(folder.Children, titles)
+	}
+
+	return titles
+}
+
 // Recursive function to add a file into the correct folder
 func recursiveAddFileInFolder(folders []models.Folder, parentID string, file models.Folder) []models.Folder {
 	var updatedFolders []models.Folder
@@ -287,12 +327,18 @@ func recursiveAddFileInFolder(folders []models.Folder, parentID string, file mod
 	return updatedFolders
 }
 
-func createFile(ctx *gin.Context, userName string, repoName string, fileId string, org string) error {
+func createFile(ctx *gin.Context, projectID, branch string, userName string, repoName string, fileId string, org string) error {
 	// Prepare the request body for GitHub API
-	requestBody, err := json.Marshal(map[string]interface{}{
+	payload := map[string]interface{}{
 		"message": "created file " + fileId,
 		"content": "IntcIjNkNGIxN2QwLTlmODUtNDViOC1iOGI1LWM5M2M0MGFmNTE3ZlwiOntcImlkXCI6XCIzZDRiMTdkMC05Zjg1LTQ1YjgtYjhiNS1jOTNjNDBhZjUxN2ZcIixcInZhbHVlXCI6W3tcImNoaWxkcmVuXCI6W3tcInRleHRcIjpcImltcG9ydCB7IHBhc3Npb24sIHBlcnNldmVyYW5jZSB9IGZyb20gJ2xpZmUnO1xcblxcbndoaWxlICh0cnVlKSB7XFxuICAgIGRyZWFtKCk7XFxuICAgIGNvZGUoKTtcXG4gICAgaW1wcm92ZSgpO1xcbn1cIn1dLFwidHlwZVwiOlwiY29kZVwiLFwiaWRcIjpcIjJkMWI1OTIwLWZlNTAtNGJhNi05NTcwLTk5ZDk1ZjhhZDNjNlwiLFwicHJvcHNcIjp7XCJsYW5ndWFnZVwiOlwiSmF2YVNjcmlwdFwiLFwidGhlbWVcIjpcIlZTQ29kZVwiLFwibm9kZVR5cGVcIjpcInZvaWRcIn19XSxcInR5cGVcIjpcIkNvZGVcIixcIm1ldGFcIjp7XCJvcmRlclwiOjEsXCJkZXB0aFwiOjB9fSxcIjQ3ODNkYTg5LWY5NGItNDNjZS1iYzkwLTdiODNkYjJiMWMxNlwiOntcImlkXCI6XCI0NzgzZGE4OS1mOTRiLTQzY2UtYmM5MC03YjgzZGIyYjFjMTZcIixcInZhbHVlXCI6W3tcImlkXCI6XCJjZWFiZTdiMS00ZjE2LTQ0NWEtOWM0Yi1mMWExMWNiNWRiNWVcIixcInR5cGVcIjpcImhlYWRpbmctb25lXCIsXCJjaGlsZHJlblwiOlt7XCJ0ZXh0XCI6XCJIZWxsbyBuZXcgZmlsZSBjcmVhdGVkXCJ9XSxcInByb3BzXCI6e1wibm9kZVR5cGVcIjpcImJsb2NrXCJ9fV0sXCJ0eXBlXCI6XCJIZWFkaW5nT25lXCIsXCJtZXRhXCI6e1wib3JkZXJcIjowLFwiZGVwdGhcIjowfX0sXCI3YjJmYzhmZS02ZWUwLTQ4OTItODBkNC1mMjkyMzEzMjU3YTdcIjp7XCJpZFwiOlwiN2IyZmM4ZmUtNmVlMC00ODkyLTgwZDQtZjI5MjMxMzI1N2E3XCIsXCJ2YWx1ZVwiOlt7XCJpZFwiOlwiYjM3ZjZkYzYtMDg5NC00ZjlkLWI4NTEtMWI4YTY1NTUxMjQ3XCIsXCJ0eXBlXCI6XCJibG9ja3F1b3RlXCIsXCJjaGlsZHJlblwiOlt7XCJib2xkXCI6dHJ1ZSxcInRleHRcIjpcIi0gT3VyIGxpZmUgaXMgd2hhdCBvdXIgdGhvdWdodHMgbWFrZSBpdFwifSx7XCJ0ZXh0XCI6XCIgKGMpIE1hcmN1cyBBdXJlbGl1c1wifV0sXCJwcm9wc1wiOntcIm5vZGVUeXBlXCI6XCJibG9ja1wifX1dLFwidHlwZVwiOlwiQmxvY2txdW90ZVwiLFwibWV0YVwiOntcIm9yZGVyXCI6MixcImRlcHRoXCI6MH19LFwiNzZiMzQ5NGQtYzhmMy00OGVhLThhODAtMjA5YThiNzI2MzRiXCI6e1wiaWRcIjpcIjc2YjM0OTRkLWM4ZjMtNDhlYS04YTgwLTIwOWE4YjcyNjM0YlwiLFwidmFsdWVcIjpbe1wiaWRcIjpcIjM3NjZmMzU4LTEzMzQtNGQ1OC05NjhlLWNiMzU0NjI0NzMwMlwiLFwidHlwZVwiOlwicGFyYWdyYXBoXCIsXCJjaGlsZHJlblwiOlt7XCJ0ZXh0XCI6XCJcIn1dLFwicHJvcHNcIjp7XCJub2RlVHlwZVwiOlwiYmxvY2tcIn19XSxcInR5cGVcIjpcIlBhcmFncmFwaFwiLFwibWV0YVwiOntcIm9yZGVyXCI6MyxcImRlcHRoXCI6MH19LFwiZDYyODg2NGUtYTY2NS00NmVjLWExNDQtMmI5MzZiNDU4Zjk0XCI6e1wiaWRcIjpcImQ2Mjg4NjRlLWE2NjUtNDZlYy1hMTQ0LTJiOTM2YjQ1OGY5NFwiLFwidmFsdWVcIjpbe1wiaWRcIjpcIjMyZGVlZDdhLTRiYzMtNDk5Yy04ZGQ2LTg3NjdmYzVkZTRmNVwiLFwidHlwZVwiOlwicGFyYWdyYXBoXCIsXCJjaGlsZHJlblwiOlt7XCJ0ZXh0XCI6XCJcIn1dLFwicHJvcHNcIjp7XCJub2RlVHlwZVwiOlwiYmxvY2tcIn19XSxcInR5cGVcIjpcIlBhcmFncmFwaFwiLFwibWV0YVwiOntcIm9yZGVyXCI6NCxcImRlcHRoXCI6MH19fSI=", // Base64-encoded empty string for folder creation
-	})
+	}
+	if branch != "" {
+		payload["branch"] = branch
+	}
+	setCommitIdentity(ctx, payload)
+
+	requestBody, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -329,23 +375,31 @@ func createFile(ctx *gin.Context, userName string, repoName string, fileId strin
 		return errors.New("failed to create a file in repository")
 	}
 
+	recordContentsCommit(ctx, projectID, branch, resp)
+
 	return nil
 }
 
-func updateFolderStructure(ctx *gin.Context, userName string, repoName string, content string, org string) error {
+func updateFolderStructure(ctx *gin.Context, projectID, branch string, userName string, repoName string, content string, org string) error {
 
 	// Get the latest SHA for the folder
-	sha, err := getFolderSHA(ctx, repoName, userName, org)
+	sha, err := getFolderSHA(ctx, repoName, userName, org, branch)
 	if err != nil {
 		return err
 	}
 
 	// Prepare the request body for GitHub API
-	requestBody, err := json.Marshal(map[string]interface{}{
+	payload := map[string]interface{}{
 		"message": "update folder",
 		"content": content, // Base64-encoded empty string for folder creation
 		"sha":     sha,
-	})
+	}
+	if branch != "" {
+		payload["branch"] = branch
+	}
+	setCommitIdentity(ctx, payload)
+
+	requestBody, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -383,10 +437,12 @@ func updateFolderStructure(ctx *gin.Context, userName string, repoName string, c
 		return errors.New("failed to update folder in repository")
 	}
 
+	recordContentsCommit(ctx, projectID, branch, resp)
+
 	return nil
 }
 
-func getFolderSHA(ctx *gin.Context, repoName string, userName string, org string) (string, error) {
+func getFolderSHA(ctx *gin.Context, repoName string, userName string, org, branch string) (string, error) {
 
 	// Create a new HTTP request to GitHub API
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/Documentthing/folder/folder.json", userName, repoName)
@@ -395,6 +451,11 @@ func getFolderSHA(ctx *gin.Context, repoName string, userName string, org string
 		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/Documentthing/folder/folder.json", org, repoName)
 	}
 
+	// Read the sha on the branch the write goes to
+	if branch != "" {
+		url += "?ref=" + branch
+	}
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create new HTTP request: %w", err)
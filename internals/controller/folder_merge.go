@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"sort"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/google/uuid"
+)
+
+// folderNode is a page of folder.json flattened out of the tree
+type folderNode struct {
+	Parent string // Empty for top level pages
+	Name   string
+	Index  int // Position among its siblings
+}
+
+// edited tells if the page was renamed or moved compared with base. Index
+// isn't compared, it shifts whenever a sibling is added or removed.
+func (n folderNode) edited(base folderNode) bool {
+	return n.Parent != base.Parent || n.Name != base.Name
+}
+
+func flattenFolders(folders []models.Folder, parent string, nodes map[string]folderNode) map[string]folderNode {
+	if nodes == nil {
+		nodes = make(map[string]folderNode)
+	}
+
+	for i, folder := range folders {
+		nodes[folder.ID.String()] = folderNode{Parent: parent, Name: folder.Name, Index: i}
+		flattenFolders(folder.Children, folder.ID.String(), nodes)
+	}
+
+	return nodes
+}
+
+// pickChange returns the side that changed compared with base, ours wins
+// when both changed
+func pickChange[T comparable](base, ours, theirs T) T {
+	if ours == base {
+		return theirs
+	}
+	return ours
+}
+
+// mergeFolderTrees merges two versions of folder.json that both changed since
+// base, page by page. Renames, moves and reorders from both sides are kept,
+// when both sides changed the same field ours (main) wins. A page deleted on
+// one side is only dropped when the other side didn't touch it.
+func mergeFolderTrees(base, ours, theirs []models.Folder) []models.Folder {
+	baseNodes := flattenFolders(base, "", nil)
+	ourNodes := flattenFolders(ours, "", nil)
+	theirNodes := flattenFolders(theirs, "", nil)
+
+	merged := make(map[string]folderNode)
+
+	ids := make(map[string]bool)
+	for id := range ourNodes {
+		ids[id] = true
+	}
+	for id := range theirNodes {
+		ids[id] = true
+	}
+
+	for id := range ids {
+		b, inBase := baseNodes[id]
+		o, inOurs := ourNodes[id]
+		t, inTheirs := theirNodes[id]
+
+		switch {
+		case inOurs && inTheirs:
+			if !inBase {
+				// Added on both sides with the same id, keep ours
+				merged[id] = o
+				continue
+			}
+			merged[id] = folderNode{
+				Parent: pickChange(b.Parent, o.Parent, t.Parent),
+				Name:   pickChange(b.Name, o.Name, t.Name),
+				Index:  pickChange(b.Index, o.Index, t.Index),
+			}
+		case inOurs:
+			// Deleted on their side, keep it only if we changed it
+			if !inBase || o.edited(b) {
+				merged[id] = o
+			}
+		case inTheirs:
+			if !inBase || t.edited(b) {
+				merged[id] = t
+			}
+		}
+	}
+
+	// A parent that was deleted, or moves that form a cycle, put the page at the top
+	for id, node := range merged {
+		if node.Parent == "" {
+			continue
+		}
+
+		if _, exists := merged[node.Parent]; !exists || folderCycle(merged, id) {
+			node.Parent = ""
+			merged[id] = node
+		}
+	}
+
+	return buildFolderTree(merged, "", ourNodes)
+}
+
+// folderCycle reports whether walking up from id comes back to id
+func folderCycle(nodes map[string]folderNode, id string) bool {
+	seen := map[string]bool{id: true}
+
+	for current := nodes[id].Parent; current != ""; current = nodes[current].Parent {
+		if seen[current] {
+			return true
+		}
+		seen[current] = true
+	}
+
+	return false
+}
+
+func buildFolderTree(nodes map[string]folderNode, parent string, ourNodes map[string]folderNode) []models.Folder {
+	var ids []string
+	for id, node := range nodes {
+		if node.Parent == parent {
+			ids = append(ids, id)
+		}
+	}
+
+	// Siblings are ordered by index, ties keep the order they have on our side
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := nodes[ids[i]], nodes[ids[j]]
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+
+		oa, aInOurs := ourNodes[ids[i]]
+		ob, bInOurs := ourNodes[ids[j]]
+		if aInOurs != bInOurs {
+			return aInOurs
+		}
+		if aInOurs && oa.Index != ob.Index {
+			return oa.Index < ob.Index
+		}
+		return ids[i] < ids[j]
+	})
+
+	folders := []models.Folder{}
+	for _, id := range ids {
+		folderID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+
+		folders = append(folders, models.Folder{
+			ID:       folderID,
+			Name:     nodes[id].Name,
+			Children: buildFolderTree(nodes, id, ourNodes),
+		})
+	}
+
+	return folders
+}
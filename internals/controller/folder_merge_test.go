@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/google/uuid"
+)
+
+func folderNames(folders []models.Folder) []string {
+	names := []string{}
+	for _, folder := range folders {
+		names = append(names, folder.Name)
+	}
+	return names
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMergeFolderTreesDropsPageDeletedWhileSiblingInserted(t *testing.T) {
+	a := models.Folder{ID: uuid.New(), Name: "A"}
+	b := models.Folder{ID: uuid.New(), Name: "B"}
+	x := models.Folder{ID: uuid.New(), Name: "X"}
+
+	base := []models.Folder{a, b}
+	ours := []models.Folder{x, a, b} // X inserted on main, shifting A and B
+	theirs := []models.Folder{a}     // B deleted on the branch
+
+	merged := folderNames(mergeFolderTrees(base, ours, theirs))
+	if want := []string{"X", "A"}; !sameNames(merged, want) {
+		t.Fatalf("merged = %v, want %v", merged, want)
+	}
+}
+
+func TestMergeFolderTreesKeepsRenamedPageDeletedOnOtherSide(t *testing.T) {
+	a := models.Folder{ID: uuid.New(), Name: "A"}
+	b := models.Folder{ID: uuid.New(), Name: "B"}
+	renamed := b
+	renamed.Name = "B2"
+
+	base := []models.Folder{a, b}
+	ours := []models.Folder{a, renamed}
+	theirs := []models.Folder{a}
+
+	merged := folderNames(mergeFolderTrees(base, ours, theirs))
+	if want := []string{"A", "B2"}; !sameNames(merged, want) {
+		t.Fatalf("merged = %v, want %v", merged, want)
+	}
+}
+
+func TestMergeFolderTreesKeepsMovesFromBothSides(t *testing.T) {
+	a := models.Folder{ID: uuid.New(), Name: "A"}
+	b := models.Folder{ID: uuid.New(), Name: "B"}
+	c := models.Folder{ID: uuid.New(), Name: "C"}
+
+	base := []models.Folder{a, b, c}
+
+	// Main renames A, the branch moves C under B
+	ours := []models.Folder{{ID: a.ID, Name: "A2"}, b, c}
+	theirs := []models.Folder{a, {ID: b.ID, Name: "B", Children: []models.Folder{c}}}
+
+	merged := mergeFolderTrees(base, ours, theirs)
+	if want := []string{"A2", "B"}; !sameNames(folderNames(merged), want) {
+		t.Fatalf("top level = %v, want %v", folderNames(merged), want)
+	}
+	if want := []string{"C"}; !sameNames(folderNames(merged[1].Children), want) {
+		t.Fatalf("children of B = %v, want %v", folderNames(merged[1].Children), want)
+	}
+}
@@ -177,6 +177,17 @@ func AcceptInvite(ctx *gin.Context) {
 		}
 	}
 
+	// Let the project know about the new member once the transaction below is committed
+	joined := false
+	defer func() {
+		if joined {
+			utils.BroadcastProjectEvent(claims.ProjectID, body.ID, utils.EventMemberJoined, utils.MemberJoinedData{
+				UserID: body.ID,
+				Role:   claims.Role,
+			})
+		}
+	}()
+
 	tx, err := initializer.DB.Begin(context.Background())
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -296,6 +307,8 @@ func AcceptInvite(ctx *gin.Context) {
 	// 		return
 	// 	}
 
+	joined = true
+
 	ctx.JSON(http.StatusOK, "Invite accepted successfully")
 }
 
@@ -433,3 +433,100 @@ func getOrganizationMembersEmails(ctx *gin.Context, orgName string) {
 		fmt.Printf("Login: %s, Email: %s\n", edge.Node.Login, edge.Node.Email)
 	}
 }
+
+// checkProjectHeader makes sure the project a handler acts on is the one in
+// X-Project-Id. RoleMiddleware only checks the role there, without this a
+// role in one project would work on any other.
+func checkProjectHeader(ctx *gin.Context, projectID uuid.UUID) bool {
+	headerID, err := uuid.Parse(ctx.GetHeader("X-Project-Id"))
+	if err != nil || headerID != projectID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not a member of this project",
+		})
+		return false
+	}
+	return true
+}
+
+func RemoveMember(ctx *gin.Context) {
+	id := ctx.Param("proj")
+	memberID := ctx.Param("user_id")
+
+	projectID, err := uuid.Parse(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectID) {
+		return
+	}
+
+	memberUUID, err := uuid.Parse(memberID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing user id "+err.Error())
+		return
+	}
+
+	var owner uuid.UUID
+
+	err = initializer.DB.QueryRow(context.Background(), `SELECT owner FROM projects WHERE id = $1`, projectID).Scan(&owner)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Unable to get project details :"+err.Error())
+		return
+	}
+
+	if owner == memberUUID {
+		ctx.JSON(http.StatusForbidden, "Project owner can't be removed from the project")
+		return
+	}
+
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to create transaction: " + err.Error(),
+		})
+		return
+	}
+
+	// Rolls back unless committed below
+	defer tx.Rollback(context.Background())
+
+	result, err := tx.Exec(context.Background(), `DELETE FROM user_project_mapping WHERE project_id = $1 AND user_id = $2`, projectID, memberUUID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error removing member from DB: " + err.Error(),
+		})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		ctx.JSON(http.StatusNotFound, "User is not a member of this project")
+		return
+	}
+
+	_, err = tx.Exec(context.Background(), `DELETE FROM org_project_user_mapping WHERE project_id = $1 AND user_id = $2`, projectID, memberUUID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error removing member from DB: " + err.Error(),
+		})
+		return
+	}
+
+	// Commit before disconnecting, a client reconnecting right away must
+	// already be refused
+	if err = tx.Commit(context.Background()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error removing member from DB: " + err.Error(),
+		})
+		return
+	}
+
+	// Close the removed member's realtime connections and tell everyone else
+	utils.DisconnectUser(projectID.String(), memberUUID.String())
+	utils.BroadcastProjectEvent(projectID.String(), ctx.GetHeader("X-User-Id"), utils.EventMemberRemoved, utils.MemberRemovedData{
+		UserID: memberUUID.String(),
+	})
+
+	ctx.JSON(http.StatusOK, "Member removed successfully")
+}
@@ -0,0 +1,486 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const folderJsonPath = "Documentthing/folder/folder.json"
+
+// errBranchMoved is returned when a branch got new commits after its head
+// was checked, they haven't been reviewed
+var errBranchMoved = errors.New("the branch changed since it was approved, review its latest changes first")
+
+// MergeConflict is a page changed differently on both sides of a merge
+type MergeConflict struct {
+	Path   string `json:"path"`
+	PageID string `json:"page_id,omitempty"`
+	Title  string `json:"title"`
+
+	// Blob shas of both sides, empty when the side deleted the file
+	OursSha   string `json:"-"`
+	TheirsSha string `json:"-"`
+}
+
+// TreeShaEntry points a path at an existing blob, a nil sha deletes the path
+type TreeShaEntry struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	Sha  *string `json:"sha"`
+}
+
+// mergeResult is what has to be applied on top of the tree of ours to get
+// the merged tree
+type mergeResult struct {
+	OursTree  string
+	Entries   []interface{}
+	Conflicts []MergeConflict
+}
+
+// getRecursiveTree maps every blob path of a tree to its sha
+func getRecursiveTree(ctx *gin.Context, repoName, userName, org, treeSha string) (map[string]string, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees/%s?recursive=1", userName, repoName, treeSha)
+	if org != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees/%s?recursive=1", org, repoName, treeSha)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get tree: %s", resp.Status)
+	}
+
+	var githubResp TreeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&githubResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	if githubResp.Truncated {
+		return nil, fmt.Errorf("tree %s is too large to merge", treeSha)
+	}
+
+	blobs := make(map[string]string)
+	for _, entry := range githubResp.Tree {
+		if entry.Type == "blob" {
+			blobs[entry.Path] = entry.Sha
+		}
+	}
+
+	return blobs, nil
+}
+
+// getBlobContent returns the decoded content of a blob
+func getBlobContent(ctx *gin.Context, repoName, userName, org, sha string) ([]byte, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/blobs/%s", userName, repoName, sha)
+	if org != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/git/blobs/%s", org, repoName, sha)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get blob: %s", resp.Status)
+	}
+
+	var blob struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&blob); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	if blob.Encoding != "base64" {
+		return []byte(blob.Content), nil
+	}
+
+	return base64.StdEncoding.DecodeString(blob.Content)
+}
+
+// getFolderBlob decodes the folder.json blob of a tree, a missing file is an empty tree
+func getFolderBlob(ctx *gin.Context, repoName, userName, org string, blobs map[string]string) ([]models.Folder, error) {
+	sha, exists := blobs[folderJsonPath]
+	if !exists {
+		return []models.Folder{}, nil
+	}
+
+	content, err := getBlobContent(ctx, repoName, userName, org, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []models.Folder
+	if err := json.Unmarshal(content, &folders); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal folder structure: %w", err)
+	}
+
+	return folders, nil
+}
+
+// threeWayMerge merges the commit theirs into ours, base being their merge
+// base. Files changed on one side only are taken from that side, folder.json
+// is merged structurally and any other file changed on both sides is a conflict.
+func threeWayMerge(ctx *gin.Context, repoName, userName, org, baseCommit, oursCommit, theirsCommit string) (mergeResult, error) {
+	var result mergeResult
+
+	trees := make(map[string]map[string]string)
+	for _, commit := range []string{baseCommit, oursCommit, theirsCommit} {
+		if _, done := trees[commit]; done {
+			continue
+		}
+
+		treeSha, err := getLatestTreeShaForCommit(ctx, repoName, userName, org, commit)
+		if err != nil {
+			return result, err
+		}
+		if commit == oursCommit {
+			result.OursTree = treeSha
+		}
+
+		blobs, err := getRecursiveTree(ctx, repoName, userName, org, treeSha)
+		if err != nil {
+			return result, err
+		}
+		trees[commit] = blobs
+	}
+
+	base, ours, theirs := trees[baseCommit], trees[oursCommit], trees[theirsCommit]
+
+	paths := make(map[string]bool)
+	for _, tree := range []map[string]string{base, ours, theirs} {
+		for path := range tree {
+			paths[path] = true
+		}
+	}
+
+	folderBothChanged := ours[folderJsonPath] != "" && theirs[folderJsonPath] != "" &&
+		base[folderJsonPath] != ours[folderJsonPath] && base[folderJsonPath] != theirs[folderJsonPath] &&
+		ours[folderJsonPath] != theirs[folderJsonPath]
+
+	var conflicting []string
+
+	for path := range paths {
+		b, o, t := base[path], ours[path], theirs[path]
+
+		// Same on both sides, or only ours changed: ours already has it
+		if o == t || b == t {
+			continue
+		}
+
+		// Only theirs changed
+		if b == o {
+			entry := TreeShaEntry{Path: path, Mode: "100644", Type: "blob"}
+			if t != "" {
+				sha := t
+				entry.Sha = &sha
+			}
+			result.Entries = append(result.Entries, entry)
+			continue
+		}
+
+		if path == folderJsonPath && folderBothChanged {
+			continue // merged below
+		}
+
+		conflicting = append(conflicting, path)
+	}
+
+	// The folder trees are needed to merge folder.json and to title conflicts
+	if len(conflicting) == 0 && !folderBothChanged {
+		return result, nil
+	}
+
+	ourFolders, err := getFolderBlob(ctx, repoName, userName, org, ours)
+	if err != nil {
+		return result, err
+	}
+	theirFolders, err := getFolderBlob(ctx, repoName, userName, org, theirs)
+	if err != nil {
+		return result, err
+	}
+
+	if folderBothChanged {
+		baseFolders, err := getFolderBlob(ctx, repoName, userName, org, base)
+		if err != nil {
+			return result, err
+		}
+
+		merged, err := json.Marshal(mergeFolderTrees(baseFolders, ourFolders, theirFolders))
+		if err != nil {
+			return result, err
+		}
+
+		result.Entries = append(result.Entries, AddOrUpdateFile{
+			Path:    folderJsonPath,
+			Mode:    "100644",
+			Type:    "blob",
+			Content: string(merged),
+		})
+	}
+
+	titles := folderTitles(ourFolders, nil)
+	folderTitles(theirFolders, titles)
+
+	for _, path := range conflicting {
+		conflict := MergeConflict{Path: path, OursSha: ours[path], TheirsSha: theirs[path]}
+		if id, ok := pageIDFromPath(path); ok {
+			conflict.PageID = id
+			conflict.Title = titles[id]
+		}
+		result.Conflicts = append(result.Conflicts, conflict)
+	}
+
+	return result, nil
+}
+
+// MergeBranch merges an editing branch into main without a pull request.
+// It fast-forwards when main didn't move, otherwise it makes a merge commit.
+// Conflicting pages are returned with 409 and nothing is merged.
+func MergeBranch(ctx *gin.Context) {
+	var body struct {
+		ProjectID    string `json:"project_id"`
+		BranchName   string `json:"branch_name"`
+		Message      string `json:"message"`
+		DeleteBranch bool   `json:"delete_branch"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if body.BranchName == "" || body.BranchName == "main" {
+		ctx.JSON(http.StatusBadRequest, "Invalid branch name")
+		return
+	}
+
+	// Editors can merge their own branches, Admins any branch
+	var owner, role string
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT eb.user_id, upm.role
+		FROM editing_branches eb
+		JOIN user_project_mapping upm ON upm.project_id = eb.project_id AND upm.user_id = $3
+		WHERE eb.project_id = $1 AND eb.branch_name = $2 AND eb.status IN ($4, $5)
+	`, projectId, body.BranchName, userID, models.BranchActive, models.BranchInReview).Scan(&owner, &role)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Branch not found")
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if owner != userID && role != string(models.RoleAdmin) {
+		ctx.JSON(http.StatusForbidden, "Only the owner of the branch or an Admin can merge it")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// Saves still waiting in the commit queue belong in the merge
+	if err := flushQueuedCommit(projectId.String(), body.BranchName); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Approvals only count for the head they were given on, saves flushed
+	// above need a review like any other commit
+	headSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, body.BranchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	approved, required, err := checkBranchApprovals(projectId, body.BranchName, headSha)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting reviews from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if !approved {
+		ctx.JSON(http.StatusForbidden, fmt.Sprintf("This branch needs %d Admin approval(s) of its latest changes before it can be merged", required))
+		return
+	}
+
+	if body.Message == "" {
+		body.Message = fmt.Sprintf("Merge branch '%s'", body.BranchName)
+	}
+
+	// An Admin merging someone else's branch credits the owner as co-author
+	sha, fastForward, conflicts, err := mergeIntoMain(ctx, projectName, userName, org, body.BranchName, headSha, body.Message, owner)
+	if err == errBranchMoved {
+		ctx.JSON(http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(conflicts) > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message":   "Some pages were changed on both main and the branch",
+			"conflicts": conflicts,
+		})
+		return
+	}
+
+	// A fast forward made no new commit, its commits are recorded already
+	if !fastForward {
+		recordCommitActivity(projectId.String(), userID, "main", sha, body.Message, []string{owner})
+	}
+
+	setEditingBranchStatus(projectId.String(), body.BranchName, models.BranchMerged)
+	markBranchReviewsMerged(projectId.String(), body.BranchName)
+	expireBranchPreviews(projectId.String(), body.BranchName)
+
+	if body.DeleteBranch {
+		if err := deleteGithubBranch(ctx, projectName, userName, org, body.BranchName); err != nil {
+			fmt.Println("Error deleting merged branch:", err)
+		}
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventBranchMerged, utils.BranchMergedData{
+		BranchName:  body.BranchName,
+		Sha:         sha,
+		FastForward: fastForward,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":      "Branch merged successfully",
+		"sha":          sha,
+		"fast_forward": fastForward,
+	})
+}
+
+// mergeIntoMain merges branchSha, the approved head of the branch, into main
+// and moves main, it returns the new head of main or the conflicts that
+// prevented the merge
+func mergeIntoMain(ctx *gin.Context, projectName, userName, org, branchName, branchSha, message string, coAuthors ...string) (string, bool, []MergeConflict, error) {
+	// Commits pushed after the approval would land unreviewed
+	currentSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, branchName)
+	if err != nil {
+		return "", false, nil, err
+	}
+	if currentSha != branchSha {
+		return "", false, nil, errBranchMoved
+	}
+
+	compare, err := compareBranches(ctx, projectName, userName, org, "main", branchSha)
+	if err != nil {
+		return "", false, nil, err
+	}
+
+	if compare.AheadBy == 0 {
+		return "", false, nil, fmt.Errorf("branch %s has nothing to merge", branchName)
+	}
+
+	// Main didn't move since the branch was created
+	if compare.BehindBy == 0 {
+		if err := updateReferenceToNewCommit(ctx, projectName, userName, org, branchSha, "main"); err != nil {
+			return "", false, nil, err
+		}
+		return branchSha, true, nil, nil
+	}
+
+	mainSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, "main")
+	if err != nil {
+		return "", false, nil, err
+	}
+
+	result, err := threeWayMerge(ctx, projectName, userName, org, compare.MergeBaseCommit.Sha, mainSha, branchSha)
+	if err != nil {
+		return "", false, nil, err
+	}
+
+	if len(result.Conflicts) > 0 {
+		return "", false, result.Conflicts, nil
+	}
+
+	treeSha := result.OursTree
+	if len(result.Entries) > 0 {
+		treeSha, err = createTree(ctx, projectName, userName, org, result.OursTree, result.Entries)
+		if err != nil {
+			return "", false, nil, err
+		}
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Merge branch '%s'", branchName)
+	}
+
+	commitSha, err := createCommit(ctx, projectName, userName, org, treeSha, []string{mainSha, branchSha}, message, coAuthors...)
+	if err != nil {
+		return "", false, nil, err
+	}
+
+	// Not forced, fails if main moved while merging
+	if err := updateReferenceToNewCommit(ctx, projectName, userName, org, commitSha, "main"); err != nil {
+		return "", false, nil, fmt.Errorf("main changed while merging, try again: %w", err)
+	}
+
+	return commitSha, false, nil, nil
+}
@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Preview is an editing branch published behind a token
+type Preview struct {
+	ID         string    `json:"id"`
+	BranchName string    `json:"branch_name"`
+	Slug       string    `json:"slug"`
+	Token      string    `json:"token"`
+	JobID      *string   `json:"job_id"`
+	CreatedBy  *string   `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// livePreview returns the token of the live preview of the branch, making
+// one the first time the branch is previewed
+func livePreview(projectId uuid.UUID, branchName, slug, userID string) (string, error) {
+	var token string
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT token FROM previews
+		WHERE project_id = $1 AND branch_name = $2 AND expired_at IS NULL
+	`, projectId, branchName).Scan(&token)
+	if err == nil {
+		return token, nil
+	}
+	if err != pgx.ErrNoRows {
+		return "", err
+	}
+
+	token, err = utils.NewPreviewToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Two previews started at once share the row that got in first
+	err = initializer.DB.QueryRow(context.Background(), `
+		INSERT INTO previews (project_id, branch_name, slug, token, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (project_id, branch_name) WHERE expired_at IS NULL
+		DO UPDATE SET updated_at = now()
+		RETURNING token
+	`, projectId, branchName, slug, token, userID).Scan(&token)
+	return token, err
+}
+
+// PublishPreview publishes an editing branch to a preview only reachable
+// with its token. Publishing the branch again updates the same preview.
+func PublishPreview(ctx *gin.Context) {
+	var body struct {
+		ProjectID  string `json:"project_id"`
+		BranchName string `json:"branch_name"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	// Only branches being edited have previews, merged and deleted ones expired theirs
+	var editing bool
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (
+			SELECT 1 FROM editing_branches
+			WHERE project_id = $1 AND branch_name = $2 AND status IN ($3, $4)
+		)
+	`, projectId, body.BranchName, models.BranchActive, models.BranchInReview).Scan(&editing)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return
+	}
+	if !editing {
+		ctx.JSON(http.StatusNotFound, "Editing branch not found")
+		return
+	}
+
+	slug, err := publishedSlug(projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	token, err := livePreview(projectId, body.BranchName, slug, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating preview : " + err.Error(),
+		})
+		return
+	}
+
+	run, err := utils.QueuePublishJob(projectId.String(), userID, models.PublishPreview)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating publish job : " + err.Error(),
+		})
+		return
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE previews SET job_id = $2, updated_at = now() WHERE token = $1
+	`, token, run.ID)
+	if err != nil {
+		fmt.Println("Error saving preview job:", err)
+	}
+
+	go run.Run(func(run *utils.PublishRun) error {
+		return publishPreview(backgroundContext(userID), projectId, userID, body.BranchName, slug, token, run)
+	})
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"job_id": run.ID,
+		"token":  token,
+		"path":   "/" + slug + "/preview/" + token,
+	})
+}
+
+// publishPreview uploads the docs of the branch to its preview
+func publishPreview(ctx *gin.Context, projectId uuid.UUID, userID, branchName, slug, token string, run *utils.PublishRun) error {
+	// The branch may have been merged while the job waited its turn
+	var live bool
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (SELECT 1 FROM previews WHERE token = $1 AND expired_at IS NULL)
+	`, token).Scan(&live)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return fmt.Errorf("preview of %s expired", branchName)
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		return err
+	}
+
+	contents, err := getAllContents(ctx, projectName, userName, org, "github", branchName)
+	if err != nil {
+		return err
+	}
+
+	contents = append(contents, embeddedDrawings(contents, projectId, userID)...)
+
+	return utils.UploadPreview(slug, token, contents, run)
+}
+
+// expireBranchPreviews takes the previews of a merged or deleted branch down
+// in the background, it waits for a preview job of the branch to finish
+func expireBranchPreviews(projectID, branchName string) {
+	go func() {
+		if err := utils.ExpirePreviews(projectID, branchName); err != nil {
+			fmt.Println("Error expiring previews:", err)
+		}
+	}()
+}
+
+// ListPreviews lists the live previews of a project
+func ListPreviews(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT id::text, branch_name, slug, token, job_id::text, created_by::text, created_at, updated_at
+		FROM previews
+		WHERE project_id = $1 AND expired_at IS NULL
+		ORDER BY updated_at DESC
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting previews from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	previews := []Preview{}
+	for rows.Next() {
+		var p Preview
+		if err := rows.Scan(&p.ID, &p.BranchName, &p.Slug, &p.Token, &p.JobID, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning previews : " + err.Error(),
+			})
+			return
+		}
+		previews = append(previews, p)
+	}
+
+	ctx.JSON(http.StatusOK, previews)
+}
+
+// servePreviewFile serves a file of a live preview, previews are kept out of
+// search engines and caches as they change with every publish
+func servePreviewFile(ctx *gin.Context, path string) {
+	ctx.Header("X-Robots-Tag", "noindex")
+	ctx.Header("Cache-Control", "no-store")
+
+	data, err := utils.ReadPreviewFile(ctx.Param("name"), ctx.Param("token"), path)
+	if err == utils.ErrPublicObjectNotFound {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Failed to read object data")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, string(data))
+}
+
+func GetPreviewFolder(ctx *gin.Context) {
+	servePreviewFile(ctx, "folder.json")
+}
+
+func GetPreviewFile(ctx *gin.Context) {
+	servePreviewFile(ctx, ctx.Param("id")+".json")
+}
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,11 +8,10 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"sync"
 
 	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -21,53 +19,45 @@ import (
 func GetPublicFolder(ctx *gin.Context) {
 	var name = ctx.Param("name")
 
-	bucket := "public-docs"
-	path := name + "/folder.json"
-
-	// Get Object
-	result, err := initializer.R2Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(path),
-	})
-	if err != nil {
-		log.Fatalf("Failed to get object: %v", err)
-	}
-	defer result.Body.Close()
-
-	// Read the object data
-	data, err := io.ReadAll(result.Body)
-	if err != nil {
-		log.Fatalf("Failed to read object data: %v", err)
-	}
-
-	ctx.JSON(http.StatusOK, string(data))
+	servePublishedFile(ctx, name, "folder.json")
 }
 
 func GetPublicFile(ctx *gin.Context) {
 	var name = ctx.Param("name")
 	var id = ctx.Param("id")
 
-	bucket := "public-docs"
-	path := name + "/" + id + ".json"
+	servePublishedFile(ctx, name, id+".json")
+}
 
-	// Get Object
-	result, err := initializer.R2Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(path),
-	})
+// servePublishedFile serves a file of the deploy the docs currently point to
+func servePublishedFile(ctx *gin.Context, slug, path string) {
+	data, err := utils.ReadPublishedFile(slug, path)
+	if err == utils.ErrPublicObjectNotFound {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
 	if err != nil {
-		log.Fatalf("Failed to get object: %v", err)
+		ctx.JSON(http.StatusInternalServerError, "Failed to read object data")
+		return
 	}
-	defer result.Body.Close()
 
-	// Read the object data
-	data, err := io.ReadAll(result.Body)
+	ctx.JSON(http.StatusOK, string(data))
+}
+
+// servePublicObject writes a published object as the JSON string the public
+// endpoints return, a missing object is a 404
+func servePublicObject(ctx *gin.Context, path string) {
+	data, err := utils.ReadPublicObject(path)
+	if err == utils.ErrPublicObjectNotFound {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
 	if err != nil {
-		log.Fatalf("Failed to read object data: %v", err)
+		ctx.JSON(http.StatusInternalServerError, "Failed to read object data")
+		return
 	}
 
 	ctx.JSON(http.StatusOK, string(data))
-
 }
 
 func PublishDocs(ctx *gin.Context) {
@@ -89,61 +79,82 @@ func PublishDocs(ctx *gin.Context) {
 		return
 	}
 
-	// getting details from DB
-	var projectName, userName, org string
-
-	err = initializer.DB.QueryRow(context.Background(), `
-		SELECT 
-		u.github_name,
-		p.name AS project_name,
-		COALESCE(p.org, '') AS project_org
-	FROM 
-		user_project_mapping upm
-	JOIN 
-		users u ON upm.user_id = u.id
-	JOIN 
-		projects p ON upm.project_id = p.id
-	WHERE 
-		p.id = $1
-		AND u.id = $2;
-		`, projectId, userID).Scan(&userName, &projectName, &org)
-
+	run, err := utils.QueuePublishJob(projectId.String(), userID, models.PublishFull)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error getting project details from DB : " + err.Error(),
+			"message": "Error creating publish job : " + err.Error(),
 		})
 		return
 	}
 
-	contents, err := getAllContents(ctx, projectName, userName, org, "github")
+	// The request context is gone once we answer
+	go run.Run(func(run *utils.PublishRun) error {
+		_, err := publishDocs(backgroundContext(userID), projectId, userID, utils.DeployFull, run)
+		return err
+	})
+
+	ctx.JSON(http.StatusAccepted, gin.H{"job_id": run.ID})
+
+}
+
+// publishDocs deploys the docs of main and marks the project published,
+// returning the slug they are served under
+func publishDocs(ctx *gin.Context, projectId uuid.UUID, userID string, mode utils.DeployMode, run *utils.PublishRun) (string, error) {
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return "", err
+	}
+
+	// Read at a fixed commit, the deploy keeps it for the webhook syncs to
+	// diff from
+	commit, err := getLatestShaFromGithub(ctx, projectName, userName, org, "main")
+	if err != nil {
+		fmt.Println("Error getting head of main, publishing without its commit:", err)
+		commit = ""
+	}
+
+	contents, err := getAllContents(ctx, projectName, userName, org, "github", commit)
+	if err != nil {
+		return "", err
 	}
 
-	uploadFiles(contents, strings.ToLower(projectName))
+	// Drawings embedded in the pages are published with them
+	contents = append(contents, embeddedDrawings(contents, projectId, userID)...)
+
+	slug := strings.ToLower(projectName)
+	if _, err := utils.Deploy(projectId.String(), slug, commit, contents, nil, mode, run); err != nil {
+		return "", err
+	}
 
 	_, err = initializer.DB.Exec(context.Background(), `
 	UPDATE public.projects
 	SET is_published = $1, published_docs_name = $2
 	WHERE id = $3;
-	`, true, strings.ToLower(projectName), projectId)
+	`, true, slug, projectId)
 
 	if err != nil {
 		log.Println("Error updating project:", err)
 	}
 
-	ctx.Status(http.StatusOK)
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPublished, utils.PublishedData{
+		Slug: slug,
+	})
 
+	return slug, nil
 }
 
-func getFolderAndFilesJsonFormGithub(ctx *gin.Context, repoName, userName, org, t, path string) ([]GitHubContent, error) {
+func getFolderAndFilesJsonFormGithub(ctx *gin.Context, repoName, userName, org, t, path, ref string) ([]GitHubContent, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", userName, repoName, path)
 
 	if org != "" {
 		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", org, repoName, path)
 	}
 
+	// The urls of the listed files keep the ref, so their content is read from it too
+	if ref != "" {
+		url += "?ref=" + ref
+	}
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new HTTP request: %w", err)
@@ -184,24 +195,38 @@ func getFolderAndFilesJsonFormGithub(ctx *gin.Context, repoName, userName, org,
 	return contents, nil
 }
 
-func getAllContents(ctx *gin.Context, repoName, userName, org, t string) ([]FileContent, error) {
+// getAllContents reads folder.json and every page at ref, the default branch
+// when ref is empty
+func getAllContents(ctx *gin.Context, repoName, userName, org, t, ref string) ([]FileContent, error) {
 	allContents := []GitHubContent{}
 
 	// Fetch Documentthing contents
-	contents, err := getFolderAndFilesJsonFormGithub(ctx, repoName, userName, org, t, "Documentthing")
+	contents, err := getFolderAndFilesJsonFormGithub(ctx, repoName, userName, org, t, "Documentthing", ref)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check for "files" and "folder" directories
+	// Check for "files" and "folder" directories, folders nested in files
+	// are read too
+	dirs := []string{}
 	for _, item := range contents {
 		if item.Name == "files" || item.Name == "folder" {
-			subContents, err := getFolderAndFilesJsonFormGithub(ctx, repoName, userName, org, t, item.Path)
-			if err != nil {
-				return nil, err
+			dirs = append(dirs, item.Path)
+		}
+	}
+	for len(dirs) > 0 {
+		subContents, err := getFolderAndFilesJsonFormGithub(ctx, repoName, userName, org, t, dirs[0], ref)
+		if err != nil {
+			return nil, err
+		}
+		dirs = dirs[1:]
+
+		for _, item := range subContents {
+			if item.Type == "dir" && strings.HasPrefix(item.Path, "Documentthing/files/") {
+				dirs = append(dirs, item.Path)
 			}
-			allContents = append(allContents, subContents...)
 		}
+		allContents = append(allContents, subContents...)
 	}
 
 	token, err := GetAccessTokenFromBackendTypeGoogle(ctx, t, repoName)
@@ -213,7 +238,9 @@ func getAllContents(ctx *gin.Context, repoName, userName, org, t string) ([]File
 
 	// Fetch contents of individual files
 	for _, item := range allContents {
-		if item.Type == "file" {
+		// Published under the same key incremental publishing uses
+		publicPath, ok := utils.PublicPath(item.Path)
+		if item.Type == "file" && ok {
 			content, err := fetchFileContent(item.Url, token)
 			if err != nil {
 				return nil, err
@@ -221,7 +248,7 @@ func getAllContents(ctx *gin.Context, repoName, userName, org, t string) ([]File
 
 			// Store the content and path in r2Contents
 			r2Contents = append(r2Contents, FileContent{
-				Path:    item.Name, // Capitalize 'Path' and 'Content' for proper struct initialization
+				Path:    publicPath, // Capitalize 'Path' and 'Content' for proper struct initialization
 				Content: content,
 			})
 		}
@@ -269,40 +296,5 @@ func fetchFileContent(url, token string) (string, error) {
 	return githubResp.Content, nil
 }
 
-func uploadFiles(contents []FileContent, projectName string) {
-	var wg sync.WaitGroup
-
-	bucket := "public-docs"
-
-	// Upload each file in a separate Goroutine
-	for _, file := range contents {
-		wg.Add(1)
-		go func(file FileContent) {
-			defer wg.Done()
-
-			// Convert the content string to a byte slice
-			fileContent := []byte(file.Content)
-			path := projectName + "/" + file.Path
-			// Upload to R2 (Cloudflare S3-compatible storage)
-			_, err := initializer.R2Client.PutObject(context.TODO(), &s3.PutObjectInput{
-				Bucket: &bucket,
-				Key:    &path, // Use the file path as the object key
-				Body:   bytes.NewReader(fileContent),
-			})
-			if err != nil {
-				log.Printf("Failed to upload %s: %v", file.Path, err)
-				return
-			}
-			fmt.Println("Uploaded:", file.Path)
-		}(file)
-	}
-
-	// Wait for all uploads to finish
-	wg.Wait()
-	fmt.Println("All files uploaded successfully!")
-}
-
-type FileContent struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
-}
+// FileContent is a file to publish, with the base64 content GitHub gives
+type FileContent = utils.FileContent
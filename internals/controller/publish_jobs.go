@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const publishJobColumns = `id, project_id, kind, status, total_files, uploaded_files, error, created_by, created_at, started_at, finished_at`
+
+func scanPublishJob(row pgx.Row) (models.PublishJob, error) {
+	var job models.PublishJob
+	err := row.Scan(&job.ID, &job.ProjectID, &job.Kind, &job.Status, &job.TotalFiles, &job.UploadedFiles,
+		&job.Error, &job.CreatedBy, &job.CreatedAt, &job.StartedAt, &job.FinishedAt)
+	return job, err
+}
+
+// GetPublishJob returns the status and progress of a publish job of the
+// project in X-Project-Id
+func GetPublishJob(ctx *gin.Context) {
+	jobId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing job id "+err.Error())
+		return
+	}
+
+	job, err := scanPublishJob(initializer.DB.QueryRow(context.Background(), `
+		SELECT `+publishJobColumns+` FROM publish_jobs WHERE id = $1 AND project_id::text = $2
+	`, jobId, ctx.GetHeader("X-Project-Id")))
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Publish job not found")
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting publish job from DB : " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// ListPublishJobs lists the latest publish jobs of a project
+func ListPublishJobs(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT `+publishJobColumns+` FROM publish_jobs WHERE project_id = $1 ORDER BY created_at DESC LIMIT 20
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting publish jobs from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	jobs := []models.PublishJob{}
+	for rows.Next() {
+		job, err := scanPublishJob(rows)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning publish jobs : " + err.Error(),
+			})
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	ctx.JSON(http.StatusOK, jobs)
+}
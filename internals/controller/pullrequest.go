@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// pullRequestSummary is what changed on a branch, used to write the pull request
+type pullRequestSummary struct {
+	Added   []ChangedPage
+	Edited  []ChangedPage
+	Renamed []renamedPage
+	Deleted []ChangedPage
+	Editors []string
+}
+
+type renamedPage struct {
+	ChangedPage
+	OldTitle string
+}
+
+// appPageURL links to a page in the app, on the given branch
+func appPageURL(projectID, pageID, branch string) string {
+	return fmt.Sprintf("%s/project/%s/docs/%s?branch=%s", utils.AppBaseURL(), projectID, pageID, url.QueryEscape(branch))
+}
+
+// summarizeBranch compares head with base and sorts the changed pages by what
+// happened to them, titles come from folder.json of the matching branch
+func summarizeBranch(ctx *gin.Context, projectName, userName, org, base, head string) (pullRequestSummary, error) {
+	var summary pullRequestSummary
+
+	compare, err := compareBranches(ctx, projectName, userName, org, base, head)
+	if err != nil {
+		return summary, err
+	}
+
+	// A branch without folder.json just has no titles, the ids are still listed
+	baseFolders, err := getFolderTree(ctx, projectName, userName, org, base)
+	if err != nil {
+		fmt.Println("Error getting folder structure of", base, ":", err)
+	}
+	headFolders, err := getFolderTree(ctx, projectName, userName, org, head)
+	if err != nil {
+		fmt.Println("Error getting folder structure of", head, ":", err)
+	}
+
+	baseTitles := folderTitles(baseFolders, nil)
+	headTitles := folderTitles(headFolders, nil)
+
+	seen := make(map[string]bool)
+	for _, file := range compare.Files {
+		id, ok := pageIDFromPath(file.Filename)
+		if !ok {
+			continue
+		}
+		seen[id] = true
+
+		page := ChangedPage{ID: id, Status: file.Status, Path: file.Filename}
+
+		switch file.Status {
+		case "added":
+			page.Title = titleOr(headTitles[id], id)
+			summary.Added = append(summary.Added, page)
+		case "removed":
+			page.Title = titleOr(baseTitles[id], id)
+			summary.Deleted = append(summary.Deleted, page)
+		default:
+			page.Title = titleOr(headTitles[id], id)
+			if old, ok := baseTitles[id]; ok && old != headTitles[id] && headTitles[id] != "" {
+				summary.Renamed = append(summary.Renamed, renamedPage{ChangedPage: page, OldTitle: old})
+				continue
+			}
+			summary.Edited = append(summary.Edited, page)
+		}
+	}
+
+	// Pages only renamed in folder.json don't show up as changed files
+	for id, title := range headTitles {
+		old, ok := baseTitles[id]
+		if seen[id] || !ok || old == title {
+			continue
+		}
+
+		summary.Renamed = append(summary.Renamed, renamedPage{
+			ChangedPage: ChangedPage{ID: id, Title: title, Status: "renamed", Path: "Documentthing/files/" + id + ".json"},
+			OldTitle:    old,
+		})
+	}
+	sort.Slice(summary.Renamed, func(i, j int) bool {
+		return summary.Renamed[i].Title < summary.Renamed[j].Title
+	})
+
+	editors := make(map[string]bool)
+	for _, commit := range compare.Commits {
+		name := commit.Commit.Author.Name
+		if name == "" || editors[name] {
+			continue
+		}
+		editors[name] = true
+		summary.Editors = append(summary.Editors, name)
+	}
+
+	return summary, nil
+}
+
+func titleOr(title, fallback string) string {
+	if title == "" {
+		return fallback
+	}
+	return title
+}
+
+// pages returns every changed page, in the order they are listed in the body
+func (s pullRequestSummary) pages() []ChangedPage {
+	var pages []ChangedPage
+	pages = append(pages, s.Added...)
+	pages = append(pages, s.Edited...)
+	for _, r := range s.Renamed {
+		pages = append(pages, r.ChangedPage)
+	}
+	pages = append(pages, s.Deleted...)
+	return pages
+}
+
+// title is a short pull request title like "Update Getting started and 2 more pages"
+func (s pullRequestSummary) title(branch string) string {
+	pages := s.pages()
+
+	switch len(pages) {
+	case 0:
+		return branch
+	case 1:
+		return "Update " + pages[0].Title
+	case 2:
+		return fmt.Sprintf("Update %s and %s", pages[0].Title, pages[1].Title)
+	default:
+		return fmt.Sprintf("Update %s and %d more pages", pages[0].Title, len(pages)-1)
+	}
+}
+
+// body writes the markdown description of the pull request
+func (s pullRequestSummary) body(projectID, branch string) string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("Changes from the editing branch `%s`.\n", branch))
+
+	writePages := func(heading string, pages []ChangedPage, link bool) {
+		if len(pages) == 0 {
+			return
+		}
+		b.WriteString(fmt.Sprintf("\n### %s\n\n", heading))
+		for _, page := range pages {
+			if link {
+				b.WriteString(fmt.Sprintf("- [%s](%s)\n", page.Title, appPageURL(projectID, page.ID, branch)))
+			} else {
+				b.WriteString(fmt.Sprintf("- %s\n", page.Title))
+			}
+		}
+	}
+
+	writePages("Added", s.Added, true)
+	writePages("Edited", s.Edited, true)
+
+	if len(s.Renamed) > 0 {
+		b.WriteString("\n### Renamed\n\n")
+		for _, page := range s.Renamed {
+			b.WriteString(fmt.Sprintf("- %s → [%s](%s)\n", page.OldTitle, page.Title, appPageURL(projectID, page.ID, branch)))
+		}
+	}
+
+	// Deleted pages don't exist on the branch anymore so there is nothing to link
+	writePages("Deleted", s.Deleted, false)
+
+	if len(s.pages()) == 0 {
+		b.WriteString("\nNo pages changed yet.\n")
+	}
+
+	if len(s.Editors) > 0 {
+		b.WriteString("\n### Edited by\n\n")
+		for _, editor := range s.Editors {
+			b.WriteString(fmt.Sprintf("- %s\n", editor))
+		}
+	}
+
+	b.WriteString(fmt.Sprintf("\n---\n[Open this branch in DocumentThing](%s/project/%s?branch=%s)\n", utils.AppBaseURL(), projectID, url.QueryEscape(branch)))
+
+	return b.String()
+}
+
+// describePullRequest builds the title and body of a pull request from the
+// branch diff, a title or description given by the user is kept as is
+func describePullRequest(ctx *gin.Context, projectID, projectName, userName, org, base, head, title, description string) (string, string, error) {
+	if title != "" && description != "" {
+		return title, description, nil
+	}
+
+	summary, err := summarizeBranch(ctx, projectName, userName, org, base, head)
+	if err != nil {
+		return "", "", err
+	}
+
+	if title == "" {
+		title = summary.title(head)
+	}
+	if description == "" {
+		description = summary.body(projectID, head)
+	}
+
+	return title, description, nil
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// RebaseConflict is a conflicting page with both versions so the user can
+// resolve it, a nil version means the page was deleted on that side
+type RebaseConflict struct {
+	MergeConflict
+	MainContent   *string `json:"main_content"`
+	BranchContent *string `json:"branch_content"`
+}
+
+// RebaseResolution is the content the user picked for a conflicting path
+// while replaying a commit, "null" deletes it like in Contents
+type RebaseResolution struct {
+	Commit  string `json:"commit"`
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// RebaseBranch replays the commits of an editing branch onto the current
+// head of main one by one. Conflicting pages of a commit are returned with 409
+// and both versions, the client calls again with a resolution for each of them
+// along with the resolutions it sent before.
+func RebaseBranch(ctx *gin.Context) {
+	var body struct {
+		ProjectID   string             `json:"project_id"`
+		BranchName  string             `json:"branch_name"`
+		Resolutions []RebaseResolution `json:"resolutions"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	var owner string
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT user_id FROM editing_branches WHERE project_id = $1 AND branch_name = $2 AND status IN ($3, $4)
+	`, projectId, body.BranchName, models.BranchActive, models.BranchInReview).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Branch not found")
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if owner != userID {
+		ctx.JSON(http.StatusForbidden, "Only the owner of the branch can rebase it")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if err := flushQueuedCommit(projectId.String(), body.BranchName); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	compare, err := compareBranches(ctx, projectName, userName, org, "main", body.BranchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if compare.BehindBy == 0 {
+		ctx.JSON(http.StatusOK, gin.H{"message": "Branch is already up to date with main"})
+		return
+	}
+
+	// The compare lists at most 250 commits, replaying a part would drop the rest
+	if compare.AheadBy > len(compare.Commits) {
+		ctx.JSON(http.StatusUnprocessableEntity, "The branch has too many commits to rebase")
+		return
+	}
+
+	mainSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, "main")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	branchSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, body.BranchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resolutions := make(map[string]string)
+	for _, r := range body.Resolutions {
+		resolutions[r.Commit+"/"+r.Path] = r.Content
+	}
+
+	// Every commit of the branch is replayed on top of main, oldest first,
+	// keeping its message and author
+	head := mainSha
+	replayed := 0
+	for _, commit := range compare.Commits {
+		if len(commit.Parents) == 0 {
+			continue
+		}
+
+		result, err := threeWayMerge(ctx, projectName, userName, org, commit.Parents[0].Sha, head, commit.Sha)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Apply the resolutions, anything left unresolved goes back to the user
+		var unresolved []RebaseConflict
+		for _, conflict := range result.Conflicts {
+			content, resolved := resolutions[commit.Sha+"/"+conflict.Path]
+			if !resolved {
+				c, err := conflictVersions(ctx, projectName, userName, org, conflict)
+				if err != nil {
+					ctx.JSON(http.StatusInternalServerError, err.Error())
+					return
+				}
+				unresolved = append(unresolved, c)
+				continue
+			}
+
+			if content == "null" {
+				result.Entries = append(result.Entries, TreeShaEntry{Path: conflict.Path, Mode: "100644", Type: "blob"})
+			} else {
+				result.Entries = append(result.Entries, AddOrUpdateFile{Path: conflict.Path, Mode: "100644", Type: "blob", Content: content})
+			}
+		}
+
+		if len(unresolved) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message":   "Some pages were changed on both main and the branch",
+				"commit":    commit.Sha,
+				"conflicts": unresolved,
+			})
+			return
+		}
+
+		// Its changes are on main already
+		if len(result.Entries) == 0 {
+			continue
+		}
+
+		treeSha, err := createTree(ctx, projectName, userName, org, result.OursTree, result.Entries)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		payload := map[string]interface{}{
+			"message": commit.Commit.Message,
+			"tree":    treeSha,
+			"parents": []string{head},
+			"author": map[string]string{
+				"name":  commit.Commit.Author.Name,
+				"email": commit.Commit.Author.Email,
+				"date":  commit.Commit.Author.Date,
+			},
+		}
+		if identity, err := commitIdentity(userID); err == nil {
+			payload["committer"] = identity
+		}
+
+		sha, err := postCommit(ctx, projectName, userName, org, payload)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		copyCommitActivity(projectId.String(), body.BranchName, commit.Sha, sha)
+		head = sha
+		replayed++
+	}
+
+	// Don't throw away commits pushed to the branch while we were rebasing
+	currentSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, body.BranchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if currentSha != branchSha {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "The branch changed while rebasing, try again"})
+		return
+	}
+
+	if err := updateReference(ctx, projectName, userName, org, head, body.BranchName, true); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE editing_branches SET base_sha = $3, updated_at = now()
+		WHERE project_id = $1 AND branch_name = $2
+	`, projectId, body.BranchName, mainSha)
+	if err != nil {
+		fmt.Println("Error updating base of rebased branch:", err)
+	}
+
+	// Approvals were given for the branch before main came in
+	resetBranchApprovals(projectId.String(), body.BranchName)
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventBranchRebased, utils.BranchData{
+		BranchName: body.BranchName,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Branch rebased successfully",
+		"sha":      head,
+		"base_sha": mainSha,
+		"commits":  replayed,
+	})
+}
+
+// conflictVersions loads the main and branch versions of a conflicting file
+func conflictVersions(ctx *gin.Context, projectName, userName, org string, conflict MergeConflict) (RebaseConflict, error) {
+	c := RebaseConflict{MergeConflict: conflict}
+
+	if conflict.OursSha != "" {
+		content, err := getBlobContent(ctx, projectName, userName, org, conflict.OursSha)
+		if err != nil {
+			return c, err
+		}
+		main := string(content)
+		c.MainContent = &main
+	}
+
+	if conflict.TheirsSha != "" {
+		content, err := getBlobContent(ctx, projectName, userName, org, conflict.TheirsSha)
+		if err != nil {
+			return c, err
+		}
+		branch := string(content)
+		c.BranchContent = &branch
+	}
+
+	return c, nil
+}
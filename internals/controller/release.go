@@ -0,0 +1,412 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Versions end up in object keys and git refs, keep them to a safe charset
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,49}$`)
+
+// Names under <slug>/ that are not free for versions
+var reservedVersions = map[string]bool{
+	"previews": true,
+	"versions": true,
+	"current":  true,
+	"deploys":  true,
+}
+
+func validVersion(version string) bool {
+	return versionPattern.MatchString(version) &&
+		!strings.HasSuffix(version, ".json") &&
+		!reservedVersions[strings.ToLower(version)]
+}
+
+// releaseRef is the git ref a release of the given kind points at
+func releaseRef(kind models.ReleaseKind, version string) string {
+	if kind == models.ReleaseBranch {
+		return "refs/heads/release/" + version
+	}
+	return "refs/tags/" + version
+}
+
+// createGithubRef creates a branch or tag ref pointing at sha
+func createGithubRef(ctx *gin.Context, repoName, userName, org, ref, sha string) error {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs", userName, repoName)
+	if org != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs", org, repoName)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"ref": ref,
+		"sha": sha,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GetAccessTokenFromBackend(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create %s: %s", ref, string(respBody))
+	}
+
+	return nil
+}
+
+// CreateRelease cuts a docs release from the head of main as a tag, or as a
+// release/<version> branch when kind is "branch". With publish set the
+// release is published right away.
+func CreateRelease(ctx *gin.Context) {
+	var body struct {
+		ProjectID string             `json:"project_id"`
+		Version   string             `json:"version"`
+		Kind      models.ReleaseKind `json:"kind"`
+		Notes     string             `json:"notes"`
+		Publish   bool               `json:"publish"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	if !validVersion(body.Version) {
+		ctx.JSON(http.StatusBadRequest, "Invalid version, use letters, digits, dots, dashes and underscores")
+		return
+	}
+
+	if body.Kind == "" {
+		body.Kind = models.ReleaseTag
+	}
+	if body.Kind != models.ReleaseTag && body.Kind != models.ReleaseBranch {
+		ctx.JSON(http.StatusBadRequest, "Kind must be tag or branch")
+		return
+	}
+
+	var exists bool
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (SELECT 1 FROM releases WHERE project_id = $1 AND version = $2)
+	`, projectId, body.Version).Scan(&exists)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting releases from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if exists {
+		ctx.JSON(http.StatusConflict, "Release "+body.Version+" already exists")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// Queued saves on main belong in the release
+	if err := flushQueuedCommit(projectId.String(), "main"); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sha, err := getLatestShaFromGithub(ctx, projectName, userName, org, "main")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ref := releaseRef(body.Kind, body.Version)
+	if err := createGithubRef(ctx, projectName, userName, org, ref, sha); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var release models.Release
+	err = initializer.DB.QueryRow(context.Background(), `
+		INSERT INTO releases (project_id, version, kind, ref, sha, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, version, kind, ref, sha, notes, created_by, created_at, published_at
+	`, projectId, body.Version, body.Kind, ref, sha, body.Notes, userID).Scan(
+		&release.ID, &release.Version, &release.Kind, &release.Ref, &release.Sha,
+		&release.Notes, &release.CreatedBy, &release.CreatedAt, &release.PublishedAt,
+	)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Release created on GitHub but saving it failed : " + err.Error(),
+		})
+		return
+	}
+
+	if body.Publish {
+		if err := publishRelease(ctx, projectId, projectName, userName, org, &release); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Release created but publishing failed : " + err.Error(),
+				"release": release,
+			})
+			return
+		}
+
+		utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPublished, utils.PublishedData{
+			Slug: strings.ToLower(projectName) + "/" + release.Version,
+		})
+	}
+
+	ctx.JSON(http.StatusCreated, release)
+}
+
+// publishRelease uploads the docs as they are at the release ref under
+// <slug>/<version>/, next to the latest docs and the other versions
+func publishRelease(ctx *gin.Context, projectId uuid.UUID, projectName, userName, org string, release *models.Release) error {
+	// Tags don't move, read them by sha. Release branches are read by name so
+	// fixes pushed to them get published too.
+	ref := release.Sha
+	if release.Kind == models.ReleaseBranch {
+		ref = strings.TrimPrefix(release.Ref, "refs/heads/")
+	}
+
+	contents, err := getAllContents(ctx, projectName, userName, org, "github", ref)
+	if err != nil {
+		return err
+	}
+
+	slug := strings.ToLower(projectName)
+	if err := utils.UploadFiles(contents, slug+"/"+release.Version, nil); err != nil {
+		return err
+	}
+
+	err = initializer.DB.QueryRow(context.Background(), `
+		UPDATE releases SET published_at = now() WHERE id = $1 RETURNING published_at
+	`, release.ID).Scan(&release.PublishedAt)
+	if err != nil {
+		return err
+	}
+
+	// The public version list is looked up by slug
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE projects SET published_docs_name = $2 WHERE id = $1 AND published_docs_name IS NULL
+	`, projectId, slug)
+	return err
+}
+
+// PublishRelease publishes, or publishes again, an existing release
+func PublishRelease(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+		Version   string `json:"version"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	release, err := getRelease(projectId, body.Version)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Release not found")
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting release from DB : " + err.Error(),
+		})
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if err := publishRelease(ctx, projectId, projectName, userName, org, &release); err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPublished, utils.PublishedData{
+		Slug: strings.ToLower(projectName) + "/" + release.Version,
+	})
+
+	ctx.JSON(http.StatusOK, release)
+}
+
+func getRelease(projectId uuid.UUID, version string) (models.Release, error) {
+	var release models.Release
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT id, version, kind, ref, sha, notes, created_by, created_at, published_at
+		FROM releases WHERE project_id = $1 AND version = $2
+	`, projectId, version).Scan(
+		&release.ID, &release.Version, &release.Kind, &release.Ref, &release.Sha,
+		&release.Notes, &release.CreatedBy, &release.CreatedAt, &release.PublishedAt,
+	)
+	return release, err
+}
+
+// ListReleases lists the releases of a project, newest first
+func ListReleases(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT id, version, kind, ref, sha, notes, created_by, created_at, published_at
+		FROM releases WHERE project_id = $1
+		ORDER BY created_at DESC
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting releases from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	releases := []models.Release{}
+	for rows.Next() {
+		var release models.Release
+		err := rows.Scan(
+			&release.ID, &release.Version, &release.Kind, &release.Ref, &release.Sha,
+			&release.Notes, &release.CreatedBy, &release.CreatedAt, &release.PublishedAt,
+		)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning releases : " + err.Error(),
+			})
+			return
+		}
+		releases = append(releases, release)
+	}
+
+	ctx.JSON(http.StatusOK, releases)
+}
+
+// GetPublicVersions lists the published versions of a documentation by slug
+func GetPublicVersions(ctx *gin.Context) {
+	name := ctx.Param("name")
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT r.version, r.notes, r.published_at
+		FROM releases r
+		JOIN projects p ON p.id = r.project_id
+		WHERE p.published_docs_name = $1 AND r.published_at IS NOT NULL
+		ORDER BY r.created_at DESC
+	`, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting versions from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	type version struct {
+		Version     string    `json:"version"`
+		Notes       string    `json:"notes"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+
+	versions := []version{}
+	for rows.Next() {
+		var v version
+		if err := rows.Scan(&v.Version, &v.Notes, &v.PublishedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning versions : " + err.Error(),
+			})
+			return
+		}
+		versions = append(versions, v)
+	}
+
+	ctx.JSON(http.StatusOK, versions)
+}
+
+// GetPublicVersionFolder serves folder.json of a published version
+func GetPublicVersionFolder(ctx *gin.Context) {
+	version := ctx.Param("version")
+	if !validVersion(version) {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
+
+	servePublicObject(ctx, ctx.Param("name")+"/"+version+"/folder.json")
+}
+
+// GetPublicVersionFile serves a page of a published version
+func GetPublicVersionFile(ctx *gin.Context) {
+	version := ctx.Param("version")
+	if !validVersion(version) {
+		ctx.JSON(http.StatusNotFound, "Not found")
+		return
+	}
+
+	servePublicObject(ctx, ctx.Param("name")+"/"+version+"/"+ctx.Param("id")+".json")
+}
@@ -0,0 +1,673 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// createReviewRequest stores a review request for a branch and assigns the
+// reviewers, reviewers who aren't members of the project are ignored
+func createReviewRequest(projectID uuid.UUID, authorID, branchName, title, description string, pr *PullRequestResponse, reviewers []string) (uuid.UUID, error) {
+	var reviewID uuid.UUID
+
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		return reviewID, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var prNumber *int
+	var prURL *string
+	if pr != nil {
+		prNumber = &pr.Number
+		prURL = &pr.HTMLURL
+	}
+
+	err = tx.QueryRow(context.Background(), `
+		INSERT INTO review_requests (project_id, author_id, branch_name, title, description, pr_number, pr_url, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, projectID, authorID, branchName, title, description, prNumber, prURL, models.ReviewOpen).Scan(&reviewID)
+	if err != nil {
+		return reviewID, err
+	}
+
+	if err := assignReviewers(tx, reviewID, projectID, authorID, reviewers); err != nil {
+		return reviewID, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return reviewID, err
+	}
+
+	setEditingBranchStatus(projectID.String(), branchName, models.BranchInReview)
+
+	return reviewID, nil
+}
+
+func assignReviewers(tx pgx.Tx, reviewID, projectID uuid.UUID, authorID string, reviewers []string) error {
+	if len(reviewers) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(context.Background(), `
+		INSERT INTO review_reviewers (review_id, user_id, decision)
+		SELECT $1, upm.user_id, $5
+		FROM user_project_mapping upm
+		WHERE upm.project_id = $2 AND upm.user_id::text = ANY($3) AND upm.user_id::text <> $4
+		ON CONFLICT (review_id, user_id) DO NOTHING
+	`, reviewID, projectID, reviewers, authorID, models.DecisionPending)
+
+	return err
+}
+
+// refreshReviewStatus recomputes the status of an open review from the
+// decisions, one request for changes blocks it and it is approved once it has
+// the Admin approvals the project requires
+func refreshReviewStatus(reviewID uuid.UUID) (models.ReviewStatus, error) {
+	var status models.ReviewStatus
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		UPDATE review_requests rr SET
+			status = CASE
+				WHEN EXISTS (
+					SELECT 1 FROM review_reviewers r WHERE r.review_id = rr.id AND r.decision = 'changes_requested'
+				) THEN 'changes_requested'
+				WHEN (
+					SELECT count(*) FROM review_reviewers r
+					JOIN user_project_mapping upm ON upm.user_id = r.user_id AND upm.project_id = rr.project_id
+					WHERE r.review_id = rr.id AND r.decision = 'approved' AND upm.role = 'Admin'
+				) >= GREATEST(p.required_approvals, 1) THEN 'approved'
+				ELSE 'open'
+			END,
+			updated_at = now()
+		FROM projects p
+		WHERE rr.id = $1 AND p.id = rr.project_id AND rr.status IN ('open', 'approved', 'changes_requested')
+		RETURNING rr.status
+	`, reviewID).Scan(&status)
+
+	return status, err
+}
+
+// checkBranchApprovals tells whether a branch may be merged. When the project
+// requires approvals its open review needs that many Admin approvals given on
+// headSha, the current head of the branch. The count is taken now rather than
+// read from the review status, the required number may have been raised since.
+func checkBranchApprovals(projectID uuid.UUID, branchName, headSha string) (bool, int, error) {
+	var required, approvals int
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT
+			p.required_approvals,
+			(
+				SELECT count(DISTINCT r.user_id) FROM review_requests rr
+				JOIN review_reviewers r ON r.review_id = rr.id
+				JOIN user_project_mapping upm ON upm.user_id = r.user_id AND upm.project_id = rr.project_id
+				WHERE rr.project_id = p.id AND rr.branch_name = $2 AND rr.status IN ($3, $4)
+					AND r.decision = $5 AND r.head_sha = $6 AND upm.role = 'Admin'
+			)
+		FROM projects p
+		WHERE p.id = $1
+	`, projectID, branchName, models.ReviewOpen, models.ReviewApproved, models.DecisionApproved, headSha).Scan(&required, &approvals)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return required == 0 || approvals >= required, required, nil
+}
+
+// resetBranchApprovals sets the approvals of the open review of a branch back
+// to pending, they were given for content the branch no longer has
+func resetBranchApprovals(projectID, branchName string) {
+	rows, err := initializer.DB.Query(context.Background(), `
+		UPDATE review_reviewers r SET decision = $3, decided_at = NULL
+		FROM review_requests rr
+		WHERE r.review_id = rr.id AND rr.project_id = $1 AND rr.branch_name = $2
+			AND rr.status IN ($4, $5, $6) AND r.decision = $7
+		RETURNING rr.id
+	`, projectID, branchName, models.DecisionPending,
+		models.ReviewOpen, models.ReviewApproved, models.ReviewChangesRequested, models.DecisionApproved)
+	if err != nil {
+		fmt.Println("Error resetting approvals:", err)
+		return
+	}
+
+	reviews := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			reviews[id] = true
+		}
+	}
+	rows.Close()
+
+	for id := range reviews {
+		status, err := refreshReviewStatus(id)
+		if err != nil {
+			fmt.Println("Error updating review status:", err)
+			continue
+		}
+
+		utils.BroadcastProjectEvent(projectID, "", utils.EventReviewUpdated, utils.ReviewData{
+			ReviewID:   id.String(),
+			BranchName: branchName,
+			Status:     string(status),
+		})
+	}
+}
+
+// markBranchReviewsMerged closes the open reviews of a merged branch
+func markBranchReviewsMerged(projectID, branchName string) {
+	_, err := initializer.DB.Exec(context.Background(), `
+		UPDATE review_requests SET status = $3, updated_at = now()
+		WHERE project_id = $1 AND branch_name = $2 AND status IN ($4, $5, $6)
+	`, projectID, branchName, models.ReviewMerged, models.ReviewOpen, models.ReviewApproved, models.ReviewChangesRequested)
+	if err != nil {
+		fmt.Println("Error marking reviews merged:", err)
+	}
+}
+
+// canRequestReview checks the branch is the user's and has no open review
+// yet, it answers the request when it doesn't
+func canRequestReview(ctx *gin.Context, projectId uuid.UUID, userID, branchName string) bool {
+	var owner string
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT user_id FROM editing_branches WHERE project_id = $1 AND branch_name = $2 AND status IN ($3, $4)
+	`, projectId, branchName, models.BranchActive, models.BranchInReview).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Branch not found")
+		return false
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting branch from DB : " + err.Error(),
+		})
+		return false
+	}
+
+	if owner != userID {
+		ctx.JSON(http.StatusForbidden, "Only the owner of the branch can request a review")
+		return false
+	}
+
+	var exists bool
+	err = initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (
+			SELECT 1 FROM review_requests
+			WHERE project_id = $1 AND branch_name = $2 AND status IN ($3, $4, $5)
+		)
+	`, projectId, branchName, models.ReviewOpen, models.ReviewApproved, models.ReviewChangesRequested).Scan(&exists)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting reviews from DB : " + err.Error(),
+		})
+		return false
+	}
+
+	if exists {
+		ctx.JSON(http.StatusConflict, "This branch already has an open review")
+		return false
+	}
+
+	return true
+}
+
+// RequestReview asks project members to review an editing branch, with
+// pr set a GitHub pull request is opened as well
+func RequestReview(ctx *gin.Context) {
+	var body struct {
+		ProjectID   string   `json:"project_id"`
+		BranchName  string   `json:"branch_name"`
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		Reviewers   []string `json:"reviewers"`
+		PR          bool     `json:"pr"`
+		Base        string   `json:"base"` // Base of the pull request, main by default
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !canRequestReview(ctx, projectId, userID, body.BranchName) {
+		return
+	}
+
+	var pr *PullRequestResponse
+	if body.PR {
+		userName, projectName, org, err := getProjectDetails(projectId, userID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error getting project details from DB : " + err.Error(),
+			})
+			return
+		}
+
+		if body.Base == "" {
+			body.Base = "main"
+		}
+
+		// Whatever the user left empty is written from the branch diff
+		body.Title, body.Description, err = describePullRequest(ctx, projectId.String(), projectName, userName, org, body.Base, body.BranchName, body.Title, body.Description)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		created, err := CreatePullRequest(ctx, userName, org, projectName, body.BranchName, body.Base, body.Title, body.Description)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		pr = &created
+	}
+
+	if body.Title == "" {
+		body.Title = body.BranchName
+	}
+
+	reviewID, err := createReviewRequest(projectId, userID, body.BranchName, body.Title, body.Description, pr, body.Reviewers)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving review request : " + err.Error(),
+		})
+		return
+	}
+
+	if pr != nil {
+		utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventPROpened, utils.BranchData{
+			BranchName: body.BranchName,
+		})
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventReviewUpdated, utils.ReviewData{
+		ReviewID:   reviewID.String(),
+		BranchName: body.BranchName,
+		Status:     string(models.ReviewOpen),
+	})
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"id": reviewID,
+	})
+}
+
+// ListReviews lists the review requests of a project, ?status= filters them
+func ListReviews(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	status := ctx.Query("status")
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT
+			rr.id, rr.branch_name, rr.author_id, rr.title, rr.description, rr.pr_number, rr.pr_url,
+			rr.status, p.required_approvals, rr.created_at, rr.updated_at
+		FROM review_requests rr
+		JOIN projects p ON p.id = rr.project_id
+		WHERE rr.project_id = $1 AND ($2 = '' OR rr.status = $2)
+		ORDER BY rr.created_at DESC
+	`, projectId, status)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting reviews from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	reviews := []models.ReviewRequest{}
+	index := make(map[uuid.UUID]int)
+	var ids []uuid.UUID
+
+	for rows.Next() {
+		var r models.ReviewRequest
+		if err := rows.Scan(&r.ID, &r.BranchName, &r.AuthorID, &r.Title, &r.Description, &r.PRNumber, &r.PRURL,
+			&r.Status, &r.RequiredApprovals, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning reviews : " + err.Error(),
+			})
+			return
+		}
+		r.Reviewers = []models.Reviewer{}
+		index[r.ID] = len(reviews)
+		ids = append(ids, r.ID)
+		reviews = append(reviews, r)
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		ctx.JSON(http.StatusOK, reviews)
+		return
+	}
+
+	reviewerRows, err := initializer.DB.Query(context.Background(), `
+		SELECT
+			r.review_id, r.user_id, COALESCE(u.name, ''), COALESCE(u.avatar_url, ''), COALESCE(upm.role, ''),
+			r.decision, r.comment, r.decided_at
+		FROM review_reviewers r
+		JOIN users u ON u.id = r.user_id
+		LEFT JOIN user_project_mapping upm ON upm.user_id = r.user_id AND upm.project_id = $2
+		WHERE r.review_id = ANY($1)
+	`, ids, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting reviewers from DB : " + err.Error(),
+		})
+		return
+	}
+	defer reviewerRows.Close()
+
+	for reviewerRows.Next() {
+		var reviewID uuid.UUID
+		var reviewer models.Reviewer
+		if err := reviewerRows.Scan(&reviewID, &reviewer.UserID, &reviewer.Name, &reviewer.Avatar, &reviewer.Role,
+			&reviewer.Decision, &reviewer.Comment, &reviewer.DecidedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning reviewers : " + err.Error(),
+			})
+			return
+		}
+
+		r := &reviews[index[reviewID]]
+		r.Reviewers = append(r.Reviewers, reviewer)
+		if reviewer.Decision == models.DecisionApproved && reviewer.Role == string(models.RoleAdmin) {
+			r.Approvals++
+		}
+	}
+
+	ctx.JSON(http.StatusOK, reviews)
+}
+
+// AddReviewers assigns more reviewers to a review, the author or an Admin can
+func AddReviewers(ctx *gin.Context) {
+	var body struct {
+		ProjectID string   `json:"project_id"`
+		ReviewID  string   `json:"review_id"`
+		Reviewers []string `json:"reviewers"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	reviewID, err := uuid.Parse(body.ReviewID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing review id "+err.Error())
+		return
+	}
+
+	review, role, err := getReviewForUser(projectId, reviewID, userID)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Review not found")
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting review from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if review.AuthorID.String() != userID && role != string(models.RoleAdmin) {
+		ctx.JSON(http.StatusForbidden, "Only the author or an Admin can add reviewers")
+		return
+	}
+
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to create transaction: " + err.Error(),
+		})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := assignReviewers(tx, reviewID, projectId, review.AuthorID.String(), body.Reviewers); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error adding reviewers : " + err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error adding reviewers : " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, "Reviewers added successfully")
+}
+
+// DecideReview records an approval or a request for changes. Assigned
+// reviewers and Admins can decide, never the author.
+func DecideReview(ctx *gin.Context) {
+	var body struct {
+		ProjectID string                `json:"project_id"`
+		ReviewID  string                `json:"review_id"`
+		Decision  models.ReviewDecision `json:"decision"`
+		Comment   string                `json:"comment"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	reviewID, err := uuid.Parse(body.ReviewID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing review id "+err.Error())
+		return
+	}
+
+	if body.Decision != models.DecisionApproved && body.Decision != models.DecisionChangesRequested {
+		ctx.JSON(http.StatusBadRequest, "Decision must be approved or changes_requested")
+		return
+	}
+
+	review, role, err := getReviewForUser(projectId, reviewID, userID)
+	if err == pgx.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, "Review not found")
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting review from DB : " + err.Error(),
+		})
+		return
+	}
+
+	if review.Status == models.ReviewMerged || review.Status == models.ReviewClosed {
+		ctx.JSON(http.StatusConflict, "This review is already "+string(review.Status))
+		return
+	}
+
+	if review.AuthorID.String() == userID {
+		ctx.JSON(http.StatusForbidden, "You can't review your own branch")
+		return
+	}
+
+	userName, projectName, org, err := getProjectDetails(projectId, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// The decision is for the branch as it is now, later commits reset it
+	headSha, err := getLatestShaFromGithub(ctx, projectName, userName, org, review.BranchName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Admins can review without being assigned, everyone else must be
+	query := `
+		UPDATE review_reviewers SET decision = $3, comment = $4, head_sha = $5, decided_at = now()
+		WHERE review_id = $1 AND user_id = $2
+	`
+	if role == string(models.RoleAdmin) {
+		query = `
+			INSERT INTO review_reviewers (review_id, user_id, decision, comment, head_sha, decided_at)
+			VALUES ($1, $2, $3, $4, $5, now())
+			ON CONFLICT (review_id, user_id) DO UPDATE SET
+				decision = EXCLUDED.decision,
+				comment = EXCLUDED.comment,
+				head_sha = EXCLUDED.head_sha,
+				decided_at = EXCLUDED.decided_at
+		`
+	}
+
+	result, err := initializer.DB.Exec(context.Background(), query, reviewID, userID, body.Decision, body.Comment, headSha)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving decision : " + err.Error(),
+		})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		ctx.JSON(http.StatusForbidden, "You are not a reviewer of this branch")
+		return
+	}
+
+	status, err := refreshReviewStatus(reviewID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating review status : " + err.Error(),
+		})
+		return
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventReviewUpdated, utils.ReviewData{
+		ReviewID:   reviewID.String(),
+		BranchName: review.BranchName,
+		Status:     string(status),
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": status,
+	})
+}
+
+// SetRequiredApprovals sets how many Admin approvals a branch needs before
+// it can be merged, 0 lets branches be merged without a review
+func SetRequiredApprovals(ctx *gin.Context) {
+	var body struct {
+		ProjectID         string `json:"project_id"`
+		RequiredApprovals int    `json:"required_approvals"`
+	}
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	if body.RequiredApprovals < 0 {
+		ctx.JSON(http.StatusBadRequest, "Required approvals can't be negative")
+		return
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `UPDATE projects SET required_approvals = $1 WHERE id = $2`, body.RequiredApprovals, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating project : " + err.Error(),
+		})
+		return
+	}
+
+	// Open reviews are approved or not against the new number
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT id FROM review_requests WHERE project_id = $1 AND status IN ($2, $3, $4)
+	`, projectId, models.ReviewOpen, models.ReviewApproved, models.ReviewChangesRequested)
+	if err != nil {
+		fmt.Println("Error getting open reviews:", err)
+	} else {
+		var reviews []uuid.UUID
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err == nil {
+				reviews = append(reviews, id)
+			}
+		}
+		rows.Close()
+
+		for _, id := range reviews {
+			if _, err := refreshReviewStatus(id); err != nil {
+				fmt.Println("Error updating review status:", err)
+			}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"required_approvals": body.RequiredApprovals,
+	})
+}
+
+// getReviewForUser returns a review of the project and the role of the user in it
+func getReviewForUser(projectID, reviewID uuid.UUID, userID string) (models.ReviewRequest, string, error) {
+	var review models.ReviewRequest
+	var role string
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT rr.id, rr.branch_name, rr.author_id, rr.status, upm.role
+		FROM review_requests rr
+		JOIN user_project_mapping upm ON upm.project_id = rr.project_id AND upm.user_id = $3
+		WHERE rr.id = $1 AND rr.project_id = $2
+	`, reviewID, projectID, userID).Scan(&review.ID, &review.BranchName, &review.AuthorID, &review.Status, &role)
+
+	return review, role, err
+}
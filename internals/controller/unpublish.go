@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// publishedSlug returns the slug the docs of a project are published under
+func publishedSlug(projectId uuid.UUID) (string, error) {
+	var slug string
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT COALESCE(published_docs_name, LOWER(name)) FROM projects WHERE id = $1
+	`, projectId).Scan(&slug)
+	return slug, err
+}
+
+// UnpublishDocs takes the public docs down, versions included
+func UnpublishDocs(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	slug, err := publishedSlug(projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	// A publish or sync still running would write the docs back
+	var deleted int
+	err = utils.StopPublishing(projectId.String(), func() error {
+		deleted, err = utils.DeletePublicPrefix(slug, nil)
+		if err != nil {
+			return fmt.Errorf("Error deleting published docs : %w", err)
+		}
+
+		utils.ForgetCurrentDeploy(slug)
+
+		_, err = initializer.DB.Exec(context.Background(), `
+			UPDATE projects SET is_published = false, current_deploy_id = NULL WHERE id = $1
+		`, projectId)
+		if err != nil {
+			return fmt.Errorf("Error updating project : %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// The versions went with the rest
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE releases SET published_at = NULL WHERE project_id = $1
+	`, projectId)
+	if err != nil {
+		fmt.Println("Error clearing published releases:", err)
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE publish_deploys SET pruned = true WHERE project_id = $1
+	`, projectId)
+	if err != nil {
+		fmt.Println("Error clearing deploys:", err)
+	}
+
+	// Previews were under the slug too
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE previews SET expired_at = now() WHERE project_id = $1 AND expired_at IS NULL
+	`, projectId)
+	if err != nil {
+		fmt.Println("Error expiring previews:", err)
+	}
+
+	utils.BroadcastProjectEvent(projectId.String(), userID, utils.EventUnpublished, utils.PublishedData{
+		Slug: slug,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// RepublishDocs uploads the docs again as a fresh deploy and then clears
+// everything else that was published, so pages deleted or renamed since the
+// last publish don't stay online. Published versions and previews are kept.
+func RepublishDocs(ctx *gin.Context) {
+	var body struct {
+		ProjectID string `json:"project_id"`
+	}
+
+	userID := ctx.GetHeader("X-User-Id")
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	slug, err := publishedSlug(projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting project details from DB : " + err.Error(),
+		})
+		return
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT version FROM releases WHERE project_id = $1 AND published_at IS NOT NULL
+	`, projectId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting releases from DB : " + err.Error(),
+		})
+		return
+	}
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	rows.Close()
+
+	run, err := utils.QueuePublishJob(projectId.String(), userID, models.PublishRepublish)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating publish job : " + err.Error(),
+		})
+		return
+	}
+
+	go run.Run(func(run *utils.PublishRun) error {
+		// Readers stay on the old deploy until the new one is complete
+		_, err := publishDocs(backgroundContext(userID), projectId, userID, utils.DeployFresh, run)
+		if err != nil {
+			return err
+		}
+
+		current, err := utils.CurrentDeploy(slug)
+		if err != nil {
+			return err
+		}
+
+		_, err = utils.DeletePublicPrefix(slug, func(key string) bool {
+			rest := strings.TrimPrefix(key, slug+"/")
+			if rest == "current.json" || strings.HasPrefix(rest, "previews/") || utils.IsDeployKey(slug, current, key) {
+				return true
+			}
+			for _, version := range versions {
+				if strings.HasPrefix(rest, version+"/") {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clear old published docs: %w", err)
+		}
+
+		_, err = initializer.DB.Exec(context.Background(), `
+			UPDATE publish_deploys SET pruned = true WHERE project_id = $1 AND id::text <> $2
+		`, projectId, current)
+		return err
+	})
+
+	ctx.JSON(http.StatusAccepted, gin.H{"job_id": run.ID})
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// WebhookDelivery is a GitHub webhook delivery as it was logged, without
+// its payload
+type WebhookDelivery struct {
+	DeliveryID string    `json:"delivery_id"`
+	Event      string    `json:"event"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error"`
+	Attempts   int       `json:"attempts"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ListWebhookDeliveries lists the webhook deliveries of a project, the failed
+// ones unless ?status= asks for another status or "all"
+func ListWebhookDeliveries(ctx *gin.Context) {
+	projectId, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	status := ctx.DefaultQuery("status", "failed")
+	if status == "all" {
+		status = ""
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(ctx.Query("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT delivery_id, event, status, error, attempts, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE project_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, projectId, status, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting webhook deliveries from DB : " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.DeliveryID, &d.Event, &d.Status, &d.Error, &d.Attempts, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error scanning webhook deliveries : " + err.Error(),
+			})
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	ctx.JSON(http.StatusOK, deliveries)
+}
+
+// ReplayWebhookDelivery handles a failed delivery again from its stored payload
+func ReplayWebhookDelivery(ctx *gin.Context) {
+	var body struct {
+		ProjectID  string `json:"project_id"`
+		DeliveryID string `json:"delivery_id"`
+	}
+
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Error while binding body")
+		return
+	}
+
+	projectId, err := uuid.Parse(body.ProjectID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, "Error while parsing project id "+err.Error())
+		return
+	}
+
+	if !checkProjectHeader(ctx, projectId) {
+		return
+	}
+
+	err = utils.ReplayDelivery(projectId.String(), body.DeliveryID)
+	if err == utils.ErrDeliveryQueued {
+		ctx.JSON(http.StatusAccepted, gin.H{"message": "Replay queued"})
+		return
+	}
+	if err == utils.ErrDeliveryNotReplayable {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"message": "Replay failed : " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Delivery replayed"})
+}
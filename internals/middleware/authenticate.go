@@ -167,13 +167,14 @@ func generateNewAccessToken(ctx *gin.Context) {
 		return
 	}
 
-	// Generate a new access token with the same claims
+	// Generate a new access token with the claims of the login one, sub is
+	// the user id ProjectMemberMiddleware reads
 	newAccessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":       claims["id"],
-		"username": claims["username"],
-		"email":    claims["email"],
-		"iat":      time.Now(),
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
+		"githubName": claims["githubName"],
+		"email":      claims["email"],
+		"sub":        claims["sub"],
+		"iat":        time.Now().Unix(),
+		"exp":        time.Now().Add(time.Hour * 24).Unix(),
 	})
 
 	tokenString, err = newAccessToken.SignedString([]byte(os.Getenv("JWTSECRET_ACCESS")))
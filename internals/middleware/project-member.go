@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ProjectMemberMiddleware only lets members of the project in the :projectID
+// param through. It must run after AuthMiddleware and takes the user from the
+// token claims instead of the X-User-Id header, since browsers can't set
+// headers on WebSocket upgrades.
+func ProjectMemberMiddleware(ctx *gin.Context) {
+	value, exists := ctx.Get("claims")
+	if !exists {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Authorization token not found",
+		})
+		return
+	}
+
+	claims, ok := value.(jwt.MapClaims)
+	if !ok {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid token",
+		})
+		return
+	}
+
+	userID, _ := claims["sub"].(string)
+	if userID == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid token",
+		})
+		return
+	}
+
+	projectID, err := uuid.Parse(ctx.Param("projectID"))
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "Error while parsing project id " + err.Error(),
+		})
+		return
+	}
+
+	var role string
+
+	err = initializer.DB.QueryRow(context.Background(), `SELECT
+  				up.role
+				FROM
+  				user_project_mapping up
+				WHERE
+  				up.project_id = $1
+  				AND up.user_id = $2;`, projectID, userID).Scan(&role)
+
+	if err == pgx.ErrNoRows {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": "You are not a member of this project",
+		})
+		return
+	} else if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "Error while retrieving data from db",
+		})
+		return
+	}
+
+	// Store the verified user for the handlers
+	ctx.Set("userID", userID)
+	ctx.Set("role", role)
+
+	ctx.Next()
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// A refreshed access token has to carry the user id like the login one,
+// project members lost the WebSocket and polling after the first refresh
+func TestProjectMemberMiddlewareAcceptsRefreshedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWTSECRET_ACCESS", "access-secret")
+	t.Setenv("JWTSECRET_REFRESH", "refresh-secret")
+
+	userID := "7b0d3b8e-4f4e-4a4c-9a51-2f0f1f3c2d10"
+	expired := signToken(t, "access-secret", jwt.MapClaims{
+		"githubName": "octocat",
+		"sub":        userID,
+		"exp":        time.Now().Add(-time.Minute).Unix(),
+	})
+	refresh := signToken(t, "refresh-secret", jwt.MapClaims{
+		"githubName": "octocat",
+		"sub":        userID,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	})
+
+	// The project id is invalid so the request stops right after the user
+	// check, before the membership lookup needs the DB
+	router := gin.New()
+	router.GET("/:projectID/poll", AuthMiddleware, ProjectMemberMiddleware, func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	// The expired access token is swapped for a new one
+	req := httptest.NewRequest(http.MethodGet, "/not-a-project/poll", nil)
+	req.AddCookie(&http.Cookie{Name: "betterDocsAT", Value: expired})
+	req.AddCookie(&http.Cookie{Name: "betterDocsRT", Value: refresh})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var refreshed string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "betterDocsAT" {
+			refreshed = cookie.Value
+		}
+	}
+	if refreshed == "" {
+		t.Fatalf("no refreshed access token, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// The next request only has the refreshed token
+	req = httptest.NewRequest(http.MethodGet, "/not-a-project/poll", nil)
+	req.AddCookie(&http.Cookie{Name: "betterDocsAT", Value: refreshed})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("refreshed token: got %d %s, want the project id error", rec.Code, rec.Body.String())
+	}
+}
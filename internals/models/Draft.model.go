@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Draft is the autosaved working copy of a page that is not committed yet
+type Draft struct {
+	ID              uuid.UUID `json:"id"`
+	Path            string    `json:"path"`
+	PageID          string    `json:"page_id"`
+	Name            string    `json:"name"`
+	Type            string    `json:"type"`
+	OriginalContent string    `json:"original_content"`
+	ChangedContent  string    `json:"changed_content"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+type BranchStatus string
+
+const (
+	BranchActive   BranchStatus = "active"    // The user is editing on it
+	BranchInReview BranchStatus = "in_review" // A pull request was opened from it
+	BranchMerged   BranchStatus = "merged"
+	BranchDeleted  BranchStatus = "deleted"
+	BranchMissing  BranchStatus = "missing" // Gone from GitHub without going through the app
+)
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PublishJobStatus string
+
+const (
+	PublishQueued    PublishJobStatus = "queued" // Waiting for another job of the project to finish
+	PublishRunning   PublishJobStatus = "running"
+	PublishFailed    PublishJobStatus = "failed"
+	PublishSucceeded PublishJobStatus = "succeeded"
+	PublishCanceled  PublishJobStatus = "canceled" // Dropped by an unpublish before it ran
+)
+
+type PublishJobKind string
+
+const (
+	PublishFull      PublishJobKind = "publish"
+	PublishRepublish PublishJobKind = "republish" // Clears the published docs first
+	PublishSync      PublishJobKind = "sync"      // Pushes to main synced by the webhook
+	PublishPreview   PublishJobKind = "preview"   // An editing branch published behind a token
+	PublishRollback  PublishJobKind = "rollback"  // The docs pointed back at an earlier deploy
+)
+
+type PublishJob struct {
+	ID            uuid.UUID        `json:"id"`
+	ProjectID     uuid.UUID        `json:"project_id"`
+	Kind          PublishJobKind   `json:"kind"`
+	Status        PublishJobStatus `json:"status"`
+	TotalFiles    int              `json:"total_files"`
+	UploadedFiles int              `json:"uploaded_files"`
+	Error         string           `json:"error"`
+	CreatedBy     *uuid.UUID       `json:"created_by"`
+	CreatedAt     time.Time        `json:"created_at"`
+	StartedAt     *time.Time       `json:"started_at"`
+	FinishedAt    *time.Time       `json:"finished_at"`
+}
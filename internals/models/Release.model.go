@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReleaseKind string
+
+const (
+	ReleaseTag    ReleaseKind = "tag"
+	ReleaseBranch ReleaseKind = "branch" // release/<version> branch that can still get fixes
+)
+
+type Release struct {
+	ID          uuid.UUID   `json:"id"`
+	Version     string      `json:"version"`
+	Kind        ReleaseKind `json:"kind"`
+	Ref         string      `json:"ref"`
+	Sha         string      `json:"sha"`
+	Notes       string      `json:"notes"`
+	CreatedBy   uuid.UUID   `json:"created_by"`
+	CreatedAt   time.Time   `json:"created_at"`
+	PublishedAt *time.Time  `json:"published_at"`
+}
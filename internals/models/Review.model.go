@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewOpen             ReviewStatus = "open"
+	ReviewApproved         ReviewStatus = "approved" // Has the Admin approvals the project requires
+	ReviewChangesRequested ReviewStatus = "changes_requested"
+	ReviewMerged           ReviewStatus = "merged"
+	ReviewClosed           ReviewStatus = "closed"
+)
+
+type ReviewDecision string
+
+const (
+	DecisionPending          ReviewDecision = "pending"
+	DecisionApproved         ReviewDecision = "approved"
+	DecisionChangesRequested ReviewDecision = "changes_requested"
+)
+
+type Reviewer struct {
+	UserID    uuid.UUID      `json:"user_id"`
+	Name      string         `json:"name"`
+	Avatar    string         `json:"avatar_url"`
+	Role      string         `json:"role"`
+	Decision  ReviewDecision `json:"decision"`
+	Comment   string         `json:"comment"`
+	DecidedAt *time.Time     `json:"decided_at"`
+}
+
+type ReviewRequest struct {
+	ID                uuid.UUID    `json:"id"`
+	BranchName        string       `json:"branch_name"`
+	AuthorID          uuid.UUID    `json:"author_id"`
+	Title             string       `json:"title"`
+	Description       string       `json:"description"`
+	PRNumber          *int         `json:"pr_number"`
+	PRURL             *string      `json:"pr_url"`
+	Status            ReviewStatus `json:"status"`
+	Approvals         int          `json:"approvals"` // Admin approvals
+	RequiredApprovals int          `json:"required_approvals"`
+	Reviewers         []Reviewer   `json:"reviewers"`
+	CreatedAt         time.Time    `json:"created_at"`
+	UpdatedAt         time.Time    `json:"updated_at"`
+}
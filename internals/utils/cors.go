@@ -7,16 +7,31 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AllowedOrigins is the list of frontends allowed to call the api
+var AllowedOrigins = []string{
+	"http://localhost:5173",
+	"https://simpledocs.vercel.app",
+	"https://www.documentthing.com",
+	"https://documentthing.com",
+	"http://localhost:5174",
+}
+
+// IsAllowedOrigin checks an origin against the configured CORS list,
+// subdomains of documentthing.com are allowed dynamically
+func IsAllowedOrigin(origin string) bool {
+	for _, allowed := range AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(origin, ".documentthing.com")
+}
+
 func Cors() gin.HandlerFunc {
 	config := cors.DefaultConfig()
 
-	config.AllowOrigins = []string{
-		"http://localhost:5173",
-		"https://simpledocs.vercel.app",
-		"https://www.documentthing.com",
-		"https://documentthing.com",
-		"http://localhost:5174",
-	}
+	config.AllowOrigins = AllowedOrigins
 
 	// Dynamically allow subdomains of documentthing.com
 	config.AllowOriginFunc = func(origin string) bool {
@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDrawingSide caps the width and height of a PNG render, bigger drawings
+// are scaled down
+const maxDrawingSide = 4096
+
+// Colors the drawing palette can use by name, hex covers the rest
+var namedColors = map[string]color.NRGBA{
+	"black":  {0, 0, 0, 255},
+	"white":  {255, 255, 255, 255},
+	"red":    {255, 0, 0, 255},
+	"green":  {0, 128, 0, 255},
+	"blue":   {0, 0, 255, 255},
+	"yellow": {255, 255, 0, 255},
+	"orange": {255, 165, 0, 255},
+	"purple": {128, 0, 128, 255},
+	"gray":   {128, 128, 128, 255},
+	"grey":   {128, 128, 128, 255},
+}
+
+// parseColor returns false for transparent and anything we can't read
+func parseColor(s string) (color.NRGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, true
+	}
+	if !strings.HasPrefix(s, "#") {
+		return color.NRGBA{}, false
+	}
+
+	hex := s[1:]
+	if len(hex) == 3 || len(hex) == 4 {
+		var expanded strings.Builder
+		for _, r := range hex {
+			expanded.WriteRune(r)
+			expanded.WriteRune(r)
+		}
+		hex = expanded.String()
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, false
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+}
+
+// coverageMask holds how much of each pixel a shape covers. An element is
+// built into one mask and blended once so overlapping pieces of a stroke
+// don't stack their opacity.
+type coverageMask struct {
+	w, h int
+	a    []float64
+}
+
+func newCoverageMask(w, h int) *coverageMask {
+	return &coverageMask{w: w, h: h, a: make([]float64, w*h)}
+}
+
+// subSamples is how many scanlines are sampled per pixel row
+const subSamples = 4
+
+// fill adds a polygon to the mask using the even-odd rule
+func (m *coverageMask) fill(pts []point) {
+	if len(pts) < 3 {
+		return
+	}
+
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range pts {
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	y0 := int(math.Max(0, math.Floor(minY)))
+	y1 := int(math.Min(float64(m.h-1), math.Ceil(maxY)))
+
+	row := make([]float64, m.w)
+	var xs []float64
+
+	for y := y0; y <= y1; y++ {
+		for i := range row {
+			row[i] = 0
+		}
+		touched := false
+
+		for s := 0; s < subSamples; s++ {
+			sy := float64(y) + (float64(s)+0.5)/subSamples
+
+			xs = xs[:0]
+			for i := range pts {
+				a, b := pts[i], pts[(i+1)%len(pts)]
+				if (a.Y <= sy && b.Y > sy) || (b.Y <= sy && a.Y > sy) {
+					xs = append(xs, a.X+(sy-a.Y)/(b.Y-a.Y)*(b.X-a.X))
+				}
+			}
+			sort.Float64s(xs)
+
+			for i := 0; i+1 < len(xs); i += 2 {
+				m.span(row, xs[i], xs[i+1])
+				touched = true
+			}
+		}
+
+		if !touched {
+			continue
+		}
+		for x, c := range row {
+			if c > m.a[y*m.w+x] {
+				m.a[y*m.w+x] = math.Min(1, c)
+			}
+		}
+	}
+}
+
+// span adds one sample scanline from xa to xb to the row, partial pixels
+// at the ends get partial coverage
+func (m *coverageMask) span(row []float64, xa, xb float64) {
+	xa, xb = math.Max(0, xa), math.Min(float64(m.w), xb)
+	if xb <= xa {
+		return
+	}
+
+	weight := 1.0 / subSamples
+	first, last := int(xa), int(math.Ceil(xb))-1
+	for x := first; x <= last && x < m.w; x++ {
+		left, right := math.Max(xa, float64(x)), math.Min(xb, float64(x+1))
+		row[x] += (right - left) * weight
+	}
+}
+
+// circle is a polygon approximating a circle
+func circle(c point, rx, ry float64, segments int) []point {
+	pts := make([]point, segments)
+	for i := range pts {
+		t := 2 * math.Pi * float64(i) / float64(segments)
+		pts[i] = point{c.X + rx*math.Cos(t), c.Y + ry*math.Sin(t)}
+	}
+	return pts
+}
+
+// stroke adds a polyline of the given width to the mask, segments are quads
+// and every joint gets a round cap
+func (m *coverageMask) stroke(pts []point, width float64, closed bool) {
+	if len(pts) == 0 {
+		return
+	}
+	if closed && len(pts) > 1 {
+		pts = append(pts, pts[0])
+	}
+
+	half := math.Max(0.5, width/2)
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		length := math.Hypot(b.X-a.X, b.Y-a.Y)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -(b.Y-a.Y)/length*half, (b.X-a.X)/length*half
+		m.fill([]point{
+			{a.X + nx, a.Y + ny},
+			{b.X + nx, b.Y + ny},
+			{b.X - nx, b.Y - ny},
+			{a.X - nx, a.Y - ny},
+		})
+	}
+
+	if half >= 1 || len(pts) == 1 {
+		for _, p := range pts {
+			m.fill(circle(p, half, half, 12))
+		}
+	}
+}
+
+// blend paints the mask onto the image in the given color
+func (m *coverageMask) blend(img *image.NRGBA, c color.NRGBA, opacity float64) {
+	for i, cov := range m.a {
+		if cov == 0 {
+			continue
+		}
+		alpha := cov * opacity * float64(c.A) / 255
+		off := i * 4
+		dst := img.Pix[off : off+4]
+
+		dstA := float64(dst[3]) / 255
+		outA := alpha + dstA*(1-alpha)
+		if outA == 0 {
+			continue
+		}
+		mix := func(src uint8, d uint8) uint8 {
+			v := (float64(src)*alpha + float64(d)*dstA*(1-alpha)) / outA
+			return uint8(math.Round(v))
+		}
+		dst[0], dst[1], dst[2] = mix(c.R, dst[0]), mix(c.G, dst[1]), mix(c.B, dst[2])
+		dst[3] = uint8(math.Round(outA * 255))
+	}
+}
+
+// RenderDrawingPNG rasterizes a drawing scene. It is meant for previews
+// where SVG can't be used, so strokes are drawn solid and text is left out
+// as we have no fonts to draw it with.
+func RenderDrawingPNG(data []byte) ([]byte, error) {
+	scene, err := parseDrawingScene(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drawing: %w", err)
+	}
+
+	elements := visibleElements(scene.Elements)
+	minX, minY, maxX, maxY := drawingBounds(elements)
+
+	// Each side on its own, a long thin drawing is small in area but not in width
+	scale := 1.0
+	if side := math.Max(maxX-minX, maxY-minY); side > maxDrawingSide {
+		scale = maxDrawingSide / side
+	}
+
+	w := int(math.Ceil((maxX - minX) * scale))
+	h := int(math.Ceil((maxY - minY) * scale))
+	w, h = min(w, maxDrawingSide), min(h, maxDrawingSide)
+	if w < 1 || h < 1 {
+		return nil, fmt.Errorf("drawing has no size")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if bg, ok := parseColor(scene.AppState.ViewBackgroundColor); ok {
+		bgMask := newCoverageMask(w, h)
+		for i := range bgMask.a {
+			bgMask.a[i] = 1
+		}
+		bgMask.blend(img, bg, 1)
+	}
+
+	// Moves scene coordinates onto the image, rotation included
+	project := func(e DrawingElement, pts []point) []point {
+		c := e.center()
+		out := make([]point, len(pts))
+		for i, p := range pts {
+			p = rotatePoint(p, c, e.Angle)
+			out[i] = point{(p.X - minX) * scale, (p.Y - minY) * scale}
+		}
+		return out
+	}
+
+	for _, e := range elements {
+		strokeColor, hasStroke := parseColor(e.StrokeColor)
+		if e.StrokeColor == "" {
+			strokeColor, hasStroke = color.NRGBA{0x1e, 0x1e, 0x1e, 255}, true
+		}
+		fillColor, hasFill := parseColor(e.BackgroundColor)
+		width := e.strokeWidth() * scale
+
+		var shape []point
+		closed := true
+		c := e.center()
+
+		switch e.Type {
+		case "rectangle":
+			shape = e.outline()
+		case "diamond":
+			shape = []point{{c.X, e.Y}, {e.X + e.Width, c.Y}, {c.X, e.Y + e.Height}, {e.X, c.Y}}
+		case "ellipse":
+			shape = circle(c, math.Abs(e.Width)/2, math.Abs(e.Height)/2, 64)
+		case "line", "arrow", "freedraw":
+			shape = e.outline()
+			closed = e.Type == "line" && len(shape) > 2 && shape[0] == shape[len(shape)-1]
+		default:
+			continue
+		}
+
+		shape = project(e, shape)
+
+		if hasFill && closed {
+			fillMask := newCoverageMask(w, h)
+			fillMask.fill(shape)
+			fillMask.blend(img, fillColor, e.opacity())
+		}
+
+		if !hasStroke {
+			continue
+		}
+
+		strokeMask := newCoverageMask(w, h)
+		strokeMask.stroke(shape, width, closed && e.Type != "line")
+
+		if e.Type == "arrow" && len(shape) >= 2 {
+			if e.EndArrowhead != nil {
+				l, r := arrowhead(shape[len(shape)-2], shape[len(shape)-1], width)
+				strokeMask.stroke([]point{l, shape[len(shape)-1], r}, width, false)
+			}
+			if e.StartArrowhead != nil {
+				l, r := arrowhead(shape[1], shape[0], width)
+				strokeMask.stroke([]point{l, shape[0], r}, width, false)
+			}
+		}
+
+		strokeMask.blend(img, strokeColor, e.opacity())
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
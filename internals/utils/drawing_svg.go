@@ -0,0 +1,402 @@
+package utils
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DrawingElement is the part of an element of the drawing scene we render
+type DrawingElement struct {
+	Type            string      `json:"type"`
+	X               float64     `json:"x"`
+	Y               float64     `json:"y"`
+	Width           float64     `json:"width"`
+	Height          float64     `json:"height"`
+	Angle           float64     `json:"angle"` // Radians, around the center
+	StrokeColor     string      `json:"strokeColor"`
+	BackgroundColor string      `json:"backgroundColor"`
+	StrokeWidth     float64     `json:"strokeWidth"`
+	StrokeStyle     string      `json:"strokeStyle"` // solid, dashed or dotted
+	Opacity         *float64    `json:"opacity"`     // 0 to 100
+	Points          [][]float64 `json:"points"`      // Relative to x and y
+	Text            string      `json:"text"`
+	FontSize        float64     `json:"fontSize"`
+	FontFamily      int         `json:"fontFamily"`
+	TextAlign       string      `json:"textAlign"`
+	Roundness       *struct {
+		Type int `json:"type"`
+	} `json:"roundness"`
+	StartArrowhead *string `json:"startArrowhead"`
+	EndArrowhead   *string `json:"endArrowhead"`
+	IsDeleted      bool    `json:"isDeleted"`
+}
+
+type drawingScene struct {
+	Elements []DrawingElement `json:"elements"`
+	AppState struct {
+		ViewBackgroundColor string `json:"viewBackgroundColor"`
+	} `json:"appState"`
+}
+
+// drawingPadding is the space kept around the elements
+const drawingPadding = 10
+
+// parseDrawingScene accepts a full scene or a bare list of elements, new
+// drawings are saved as []
+func parseDrawingScene(data []byte) (drawingScene, error) {
+	var scene drawingScene
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &scene.Elements)
+		return scene, err
+	}
+
+	err := json.Unmarshal(trimmed, &scene)
+	return scene, err
+}
+
+type point struct{ X, Y float64 }
+
+func rotatePoint(p, center point, angle float64) point {
+	if angle == 0 {
+		return p
+	}
+	sin, cos := math.Sincos(angle)
+	dx, dy := p.X-center.X, p.Y-center.Y
+	return point{center.X + dx*cos - dy*sin, center.Y + dx*sin + dy*cos}
+}
+
+func (e DrawingElement) center() point {
+	return point{e.X + e.Width/2, e.Y + e.Height/2}
+}
+
+// outline returns the points that bound the element before rotation
+func (e DrawingElement) outline() []point {
+	if len(e.Points) > 0 {
+		pts := make([]point, 0, len(e.Points))
+		for _, p := range e.Points {
+			if len(p) >= 2 {
+				pts = append(pts, point{e.X + p[0], e.Y + p[1]})
+			}
+		}
+		return pts
+	}
+
+	return []point{
+		{e.X, e.Y},
+		{e.X + e.Width, e.Y},
+		{e.X + e.Width, e.Y + e.Height},
+		{e.X, e.Y + e.Height},
+	}
+}
+
+// visibleElements drops deleted elements and the ones we don't know
+func visibleElements(elements []DrawingElement) []DrawingElement {
+	var visible []DrawingElement
+	for _, e := range elements {
+		if e.IsDeleted {
+			continue
+		}
+		switch e.Type {
+		case "rectangle", "diamond", "ellipse", "arrow", "line", "freedraw", "text":
+			visible = append(visible, e)
+		}
+	}
+	return visible
+}
+
+// drawingBounds is the box around every element, rotation included
+func drawingBounds(elements []DrawingElement) (minX, minY, maxX, maxY float64) {
+	if len(elements) == 0 {
+		return 0, 0, 100, 100
+	}
+
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+
+	for _, e := range elements {
+		c := e.center()
+		for _, p := range e.outline() {
+			p = rotatePoint(p, c, e.Angle)
+			minX, minY = math.Min(minX, p.X), math.Min(minY, p.Y)
+			maxX, maxY = math.Max(maxX, p.X), math.Max(maxY, p.Y)
+		}
+	}
+
+	if math.IsInf(minX, 0) {
+		return 0, 0, 100, 100
+	}
+
+	return minX - drawingPadding, minY - drawingPadding, maxX + drawingPadding, maxY + drawingPadding
+}
+
+// Colors go straight into attributes, anything that isn't a plain color is dropped
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]{1,30})$`)
+
+func safeColor(color, fallback string) string {
+	if color == "" || !colorPattern.MatchString(color) {
+		return fallback
+	}
+	return color
+}
+
+func (e DrawingElement) strokeWidth() float64 {
+	if e.StrokeWidth <= 0 {
+		return 1
+	}
+	return e.StrokeWidth
+}
+
+func (e DrawingElement) opacity() float64 {
+	if e.Opacity == nil {
+		return 1
+	}
+	return math.Max(0, math.Min(100, *e.Opacity)) / 100
+}
+
+func (e DrawingElement) fontSize() float64 {
+	if e.FontSize <= 0 {
+		return 20
+	}
+	return e.FontSize
+}
+
+func fontFamily(family int) string {
+	switch family {
+	case 2:
+		return "Helvetica, Arial, sans-serif"
+	case 3:
+		return "Cascadia, Consolas, monospace"
+	default:
+		return "Virgil, Segoe UI Emoji, sans-serif"
+	}
+}
+
+// arrowhead returns the two wings of an arrowhead at tip, coming from prev
+func arrowhead(prev, tip point, width float64) (point, point) {
+	length := math.Max(10, width*4)
+	angle := math.Atan2(tip.Y-prev.Y, tip.X-prev.X)
+	spread := math.Pi / 7
+
+	left := point{tip.X - length*math.Cos(angle-spread), tip.Y - length*math.Sin(angle-spread)}
+	right := point{tip.X - length*math.Cos(angle+spread), tip.Y - length*math.Sin(angle+spread)}
+	return left, right
+}
+
+func svgPoints(pts []point) string {
+	parts := make([]string, len(pts))
+	for i, p := range pts {
+		parts[i] = fmt.Sprintf("%.2f,%.2f", p.X, p.Y)
+	}
+	return strings.Join(parts, " ")
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// RenderDrawingSVG turns a drawing scene into a standalone SVG
+func RenderDrawingSVG(data []byte) ([]byte, error) {
+	scene, err := parseDrawingScene(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drawing: %w", err)
+	}
+
+	elements := visibleElements(scene.Elements)
+	minX, minY, maxX, maxY := drawingBounds(elements)
+	width, height := maxX-minX, maxY-minY
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%.2f %.2f %.2f %.2f" width="%.0f" height="%.0f">`,
+		minX, minY, width, height, math.Ceil(width), math.Ceil(height))
+
+	if bg := safeColor(scene.AppState.ViewBackgroundColor, ""); bg != "" && bg != "transparent" {
+		fmt.Fprintf(&b, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`, minX, minY, width, height, bg)
+	}
+
+	for _, e := range elements {
+		writeSVGElement(&b, e)
+	}
+
+	b.WriteString(`</svg>`)
+	return b.Bytes(), nil
+}
+
+func writeSVGElement(b *bytes.Buffer, e DrawingElement) {
+	stroke := safeColor(e.StrokeColor, "#1e1e1e")
+	fill := safeColor(e.BackgroundColor, "transparent")
+	if fill == "transparent" {
+		fill = "none"
+	}
+
+	dash := ""
+	switch e.StrokeStyle {
+	case "dashed":
+		dash = fmt.Sprintf(` stroke-dasharray="%.1f %.1f"`, e.strokeWidth()*4, e.strokeWidth()*3)
+	case "dotted":
+		dash = fmt.Sprintf(` stroke-dasharray="%.1f %.1f"`, e.strokeWidth(), e.strokeWidth()*3)
+	}
+
+	c := e.center()
+	fmt.Fprintf(b, `<g opacity="%.2f"`, e.opacity())
+	if e.Angle != 0 {
+		fmt.Fprintf(b, ` transform="rotate(%.3f %.2f %.2f)"`, e.Angle*180/math.Pi, c.X, c.Y)
+	}
+	b.WriteString(`>`)
+
+	style := fmt.Sprintf(`stroke="%s" stroke-width="%.2f" stroke-linecap="round" stroke-linejoin="round"%s`, stroke, e.strokeWidth(), dash)
+
+	switch e.Type {
+	case "rectangle":
+		radius := 0.0
+		if e.Roundness != nil {
+			radius = math.Min(math.Abs(e.Width), math.Abs(e.Height)) * 0.25
+		}
+		fmt.Fprintf(b, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" rx="%.2f" fill="%s" %s/>`,
+			math.Min(e.X, e.X+e.Width), math.Min(e.Y, e.Y+e.Height), math.Abs(e.Width), math.Abs(e.Height), radius, fill, style)
+
+	case "diamond":
+		pts := []point{
+			{c.X, e.Y},
+			{e.X + e.Width, c.Y},
+			{c.X, e.Y + e.Height},
+			{e.X, c.Y},
+		}
+		fmt.Fprintf(b, `<polygon points="%s" fill="%s" %s/>`, svgPoints(pts), fill, style)
+
+	case "ellipse":
+		fmt.Fprintf(b, `<ellipse cx="%.2f" cy="%.2f" rx="%.2f" ry="%.2f" fill="%s" %s/>`,
+			c.X, c.Y, math.Abs(e.Width)/2, math.Abs(e.Height)/2, fill, style)
+
+	case "line", "arrow", "freedraw":
+		pts := e.outline()
+		if len(pts) == 0 {
+			break
+		}
+
+		// A closed line with a background is a polygon
+		lineFill := "none"
+		if e.Type == "line" && len(pts) > 2 && pts[0] == pts[len(pts)-1] {
+			lineFill = fill
+		}
+		fmt.Fprintf(b, `<polyline points="%s" fill="%s" %s/>`, svgPoints(pts), lineFill, style)
+
+		if e.Type == "arrow" && len(pts) >= 2 {
+			plain := fmt.Sprintf(`stroke="%s" stroke-width="%.2f" stroke-linecap="round" fill="none"`, stroke, e.strokeWidth())
+			if e.EndArrowhead != nil {
+				l, r := arrowhead(pts[len(pts)-2], pts[len(pts)-1], e.strokeWidth())
+				fmt.Fprintf(b, `<polyline points="%s" %s/>`, svgPoints([]point{l, pts[len(pts)-1], r}), plain)
+			}
+			if e.StartArrowhead != nil {
+				l, r := arrowhead(pts[1], pts[0], e.strokeWidth())
+				fmt.Fprintf(b, `<polyline points="%s" %s/>`, svgPoints([]point{l, pts[0], r}), plain)
+			}
+		}
+
+	case "text":
+		size := e.fontSize()
+		x, anchor := e.X, "start"
+		switch e.TextAlign {
+		case "center":
+			x, anchor = e.X+e.Width/2, "middle"
+		case "right":
+			x, anchor = e.X+e.Width, "end"
+		}
+
+		fmt.Fprintf(b, `<text x="%.2f" y="%.2f" font-size="%.2f" font-family="%s" fill="%s" text-anchor="%s" dominant-baseline="text-before-edge">`,
+			x, e.Y, size, fontFamily(e.FontFamily), stroke, anchor)
+		for i, line := range strings.Split(e.Text, "\n") {
+			dy := 0.0
+			if i > 0 {
+				dy = size * 1.25
+			}
+			fmt.Fprintf(b, `<tspan x="%.2f" dy="%.2f">%s</tspan>`, x, dy, escapeXML(line))
+		}
+		b.WriteString(`</text>`)
+	}
+
+	b.WriteString(`</g>`)
+}
+
+// GitBlobSha is the sha git gives a blob with this content, so renders of
+// drawings read from GitHub and from the published bucket share cache entries
+func GitBlobSha(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// renderCacheSize is how many renders are kept in memory
+const renderCacheSize = 256
+
+type renderCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// drawingRenders caches renders by blob sha and format, the same blob always
+// renders the same so entries never go stale
+var drawingRenders = struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}{
+	entries: make(map[string]*list.Element),
+	order:   list.New(),
+}
+
+// RenderDrawingCached renders a drawing as "svg" or "png", reusing an
+// earlier render of the same blob
+func RenderDrawingCached(blobSha, format string, data []byte) ([]byte, error) {
+	key := blobSha + ":" + format
+
+	drawingRenders.Lock()
+	if el, ok := drawingRenders.entries[key]; ok {
+		drawingRenders.order.MoveToFront(el)
+		cached := el.Value.(*renderCacheEntry).data
+		drawingRenders.Unlock()
+		return cached, nil
+	}
+	drawingRenders.Unlock()
+
+	var rendered []byte
+	var err error
+	switch format {
+	case "svg":
+		rendered, err = RenderDrawingSVG(data)
+	case "png":
+		rendered, err = RenderDrawingPNG(data)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	drawingRenders.Lock()
+	defer drawingRenders.Unlock()
+
+	if _, ok := drawingRenders.entries[key]; !ok {
+		drawingRenders.entries[key] = drawingRenders.order.PushFront(&renderCacheEntry{key: key, data: rendered})
+		for drawingRenders.order.Len() > renderCacheSize {
+			oldest := drawingRenders.order.Back()
+			drawingRenders.order.Remove(oldest)
+			delete(drawingRenders.entries, oldest.Value.(*renderCacheEntry).key)
+		}
+	}
+
+	return rendered, nil
+}
@@ -197,14 +197,7 @@ func SendInviteMail(jwt, name, projectName, invitedBy, role, email string) error
 		return fmt.Errorf("error parsing inline template: %w", err)
 	}
 
-	var baseURL string
-
-	// Corrected: use os.Getenv instead of os.GetEnv
-	if os.Getenv("RAILS_ENVIRONMENT") == "PROD" {
-		baseURL = "https://documentthing.com"
-	} else {
-		baseURL = "http://localhost:5173"
-	}
+	baseURL := AppBaseURL()
 
 	// Data to render the template
 	data := map[string]interface{}{
@@ -246,3 +239,12 @@ func sendSMTPEmail(subject, recipient, htmlBody string) error {
 	}
 	return nil
 }
+
+// AppBaseURL is the address of the frontend, used for links in mails and pull requests
+func AppBaseURL() string {
+	// Corrected: use os.Getenv instead of os.GetEnv
+	if os.Getenv("RAILS_ENVIRONMENT") == "PROD" {
+		return "https://documentthing.com"
+	}
+	return "http://localhost:5173"
+}
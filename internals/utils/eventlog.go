@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+)
+
+// Number of events kept per project for replay, clients that missed more
+// than this have to resync
+const eventLogSize = 500
+
+// marshalEvent encodes an event, dropping its data when it would not fit in
+// a pub/sub message
+func marshalEvent(event *Event) ([]byte, error) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	// Big payloads (e.g. a whole folder tree) are dropped, clients refetch
+	if len(message) > maxEventSize {
+		event.Data = nil
+		event.Truncated = true
+		return json.Marshal(event)
+	}
+
+	return message, nil
+}
+
+// logEvent gives the event the next sequence number of its project and
+// stores it in the event log, it returns the encoded event
+func logEvent(event *Event) ([]byte, error) {
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// The row lock keeps sequence numbers of a project strictly increasing
+	err = tx.QueryRow(context.Background(), `
+		INSERT INTO realtime_sequences (project_id, seq) VALUES ($1, 1)
+		ON CONFLICT (project_id) DO UPDATE SET seq = realtime_sequences.seq + 1
+		RETURNING seq
+	`, event.ProjectID).Scan(&event.Seq)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := marshalEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(context.Background(), `INSERT INTO realtime_events (project_id, seq, payload) VALUES ($1, $2, $3)`,
+		event.ProjectID, event.Seq, string(message))
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the log bounded
+	_, err = tx.Exec(context.Background(), `DELETE FROM realtime_events WHERE project_id = $1 AND seq <= $2`,
+		event.ProjectID, event.Seq-eventLogSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// currentEventSeq returns the sequence number of the last event of a project
+func currentEventSeq(projectID string) (int64, error) {
+	var seq int64
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT COALESCE((SELECT seq FROM realtime_sequences WHERE project_id = $1), 0)
+	`, projectID).Scan(&seq)
+
+	return seq, err
+}
+
+// eventsSince returns the encoded events after lastSeq in order. resync is
+// true when some of them are no longer in the log, or lastSeq is ahead of the
+// server (e.g. the database was reset), and the client must refetch everything.
+func eventsSince(projectID string, lastSeq int64) (messages [][]byte, current int64, resync bool, err error) {
+	current, err = currentEventSeq(projectID)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if lastSeq > current {
+		return nil, current, true, nil
+	}
+
+	if lastSeq == current {
+		return nil, current, false, nil
+	}
+
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT seq, payload FROM realtime_events WHERE project_id = $1 AND seq > $2 AND seq <= $3 ORDER BY seq
+	`, projectID, lastSeq, current)
+	if err != nil {
+		return nil, current, false, err
+	}
+	defer rows.Close()
+
+	expected := lastSeq + 1
+	for rows.Next() {
+		var seq int64
+		var payload string
+		if err := rows.Scan(&seq, &payload); err != nil {
+			return nil, current, false, err
+		}
+
+		// A hole means the events were pruned or never logged
+		if seq != expected {
+			return nil, current, true, nil
+		}
+
+		messages = append(messages, []byte(payload))
+		expected++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, current, false, err
+	}
+
+	if expected != current+1 {
+		return nil, current, true, nil
+	}
+
+	return messages, current, false, nil
+}
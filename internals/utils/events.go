@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventProtocolVersion is sent with every message so clients can detect
+// envelope changes and refuse messages they don't understand
+const EventProtocolVersion = 1
+
+// EventType identifies the kind of message sent over the realtime channels
+type EventType string
+
+const (
+	// Server originated project events
+	EventPageSaved     EventType = "page_saved"
+	EventFolderChanged EventType = "folder_changed"
+	EventBranchCreated EventType = "branch_created"
+	EventBranchRenamed EventType = "branch_renamed"
+	EventBranchMerged  EventType = "branch_merged"
+	EventBranchRebased EventType = "branch_rebased"
+	EventPROpened      EventType = "pr_opened"
+	EventReviewUpdated EventType = "review_updated"
+	EventMemberJoined  EventType = "member_joined"
+	EventMemberRemoved EventType = "member_removed"
+	EventPublished     EventType = "published"
+	EventUnpublished   EventType = "unpublished"
+	EventPublishJob    EventType = "publish_job"   // A publish job finished
+	EventChangelog     EventType = "changelog"     // Weekly changelog saved
+	EventCommitFailed  EventType = "commit_failed" // Queued saves couldn't be committed
+
+	// Resume messages, see HandleWebSocket
+	EventSync           EventType = "sync"
+	EventResyncRequired EventType = "resync_required"
+
+	// Presence messages
+	EventPresenceSnapshot EventType = "presence_snapshot"
+	EventPresenceJoin     EventType = "presence_join"
+	EventPresenceLeave    EventType = "presence_leave"
+	EventPresenceMove     EventType = "presence_move"
+	EventPresenceUpdate   EventType = "presence_update" // Sent by clients
+
+	// Protocol messages
+	EventPing      EventType = "ping"
+	EventPong      EventType = "pong"
+	EventHeartbeat EventType = "heartbeat"
+	EventError     EventType = "error"
+)
+
+// Event is the versioned envelope for every message sent to clients
+type Event struct {
+	Version   int         `json:"v"`
+	Seq       int64       `json:"seq,omitempty"` // Per project, only set on logged project events
+	Type      EventType   `json:"type"`
+	ProjectID string      `json:"project_id"`
+	Actor     string      `json:"actor,omitempty"` // user id of the user who caused the event
+	Data      interface{} `json:"data,omitempty"`
+	SentAt    time.Time   `json:"sent_at"`
+	Truncated bool        `json:"truncated,omitempty"` // Data was dropped to fit the pub/sub limit, refetch instead
+}
+
+// ClientMessage is the envelope clients use when sending messages to the server
+type ClientMessage struct {
+	Version int             `json:"v"`
+	Type    EventType       `json:"type"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type PageSavedData struct {
+	Branch  string   `json:"branch"`
+	Paths   []string `json:"paths"`
+	Message string   `json:"message"`
+}
+
+type FolderChangedData struct {
+	Branch   string      `json:"branch"`
+	ParentID string      `json:"parent_id"`
+	Folder   interface{} `json:"folder"`
+}
+
+type BranchData struct {
+	BranchName string `json:"branch_name"`
+}
+
+type BranchRenamedData struct {
+	OldName    string `json:"old_name"`
+	BranchName string `json:"branch_name"`
+}
+
+type BranchMergedData struct {
+	BranchName  string `json:"branch_name"`
+	Sha         string `json:"sha"`
+	FastForward bool   `json:"fast_forward"`
+}
+
+type ReviewData struct {
+	ReviewID   string `json:"review_id"`
+	BranchName string `json:"branch_name"`
+	Status     string `json:"status"`
+}
+
+type MemberJoinedData struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type MemberRemovedData struct {
+	UserID string `json:"user_id"`
+}
+
+type PublishedData struct {
+	Slug string `json:"slug"`
+}
+
+type PublishJobData struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	TotalFiles    int    `json:"total_files"`
+	UploadedFiles int    `json:"uploaded_files"`
+}
+
+type CommitFailedData struct {
+	Branch string   `json:"branch"`
+	Paths  []string `json:"paths"`
+	Users  []string `json:"users"` // Authors of the saves
+	Error  string   `json:"error"`
+}
+
+type ChangelogData struct {
+	ID    string    `json:"id"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// SyncData tells a client the sequence number it is caught up to
+type SyncData struct {
+	Seq      int64 `json:"seq"`
+	Replayed int   `json:"replayed"`
+}
+
+type ErrorData struct {
+	Message string `json:"message"`
+}
+
+// NewEvent builds an event envelope with the current protocol version
+func NewEvent(projectID, actor string, eventType EventType, data interface{}) Event {
+	return Event{
+		Version:   EventProtocolVersion,
+		Type:      eventType,
+		ProjectID: projectID,
+		Actor:     actor,
+		Data:      data,
+		SentAt:    time.Now().UTC(),
+	}
+}
+
+// Largest event that still fits in a pub/sub message with its envelope
+const maxEventSize = maxNotifyPayload - 512
+
+// BroadcastProjectEvent sends a server originated event to every client
+// connected to the project on any instance, both over WebSocket and long polling
+func BroadcastProjectEvent(projectID, actor string, eventType EventType, data interface{}) {
+	event := NewEvent(projectID, actor, eventType, data)
+
+	message, err := logEvent(&event)
+	if err != nil {
+		// Still deliver it live, reconnecting clients just won't see it replayed
+		fmt.Println("Error logging event:", err)
+
+		event.Seq = 0
+		message, err = marshalEvent(&event)
+		if err != nil {
+			fmt.Println("Error marshaling event:", err)
+			return
+		}
+	}
+
+	publishRealtime(RealtimeMessage{
+		Kind:      realtimeKindEvent,
+		ProjectID: projectID,
+		Payload:   message,
+	})
+}
@@ -15,8 +15,12 @@ import (
 )
 
 func GetAccessTokenFromBackend(ctx *gin.Context) (string, error) {
+	return GetAccessTokenForUser(ctx.GetHeader("X-User-Id"))
+}
 
-	id := ctx.GetHeader("X-User-Id")
+// GetAccessTokenForUser returns the github token of a user, for code running
+// outside of a request like cron jobs
+func GetAccessTokenForUser(id string) (string, error) {
 
 	var encryptedToken, name string
 	var githubID int
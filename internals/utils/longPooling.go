@@ -10,6 +10,7 @@ import (
 
 type Update struct { // The updated content
 	UpdatedBy string `json:"updatedBy"` // The userID of the user who made the update
+	Event     Event  `json:"event"`     // The project event that caused the update
 }
 
 type Project struct {
@@ -17,7 +18,7 @@ type Project struct {
 }
 
 var (
-	projects = make(map[string]*Project) // Map of project IDs to Project structure
+	projects = make(map[string]*Project) // Map of project IDs to Project structure, local to this instance and fed by pubsub.go
 	mutex    = &sync.Mutex{}             // Protect access to the map
 )
 
@@ -36,9 +37,11 @@ func LongPollHandler(c *gin.Context) {
 		// Return the update along with the user who made the update
 		c.JSON(http.StatusOK, gin.H{
 			"updatedBy": update.UpdatedBy,
+			"event":     update.Event,
 		})
 	case <-time.After(300 * time.Second): // Timeout after 300 seconds
 		c.JSON(http.StatusNoContent, gin.H{"message": "No updates"})
+	case <-c.Request.Context().Done(): // Client went away
 	}
 }
 
@@ -51,20 +54,23 @@ func addUserToProject(projectID string) chan Update {
 		projects[projectID] = &Project{Users: []chan Update{}}
 	}
 
-	// Create a new channel for the user
-	userChannel := make(chan Update)
+	// Create a new channel for the user. It only lives for this poll, the
+	// buffer keeps an update that arrives before the poll returns without
+	// blocking the sender. Updates sent between two polls are not kept.
+	userChannel := make(chan Update, 1)
 	projects[projectID].Users = append(projects[projectID].Users, userChannel)
 	return userChannel
 }
 
 // NotifyUsers notifies all users in the project about an update, including who made the update
-func NotifyUsers(projectID string, updatedBy string) {
+func NotifyUsers(projectID string, event Event) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
 	if project, exists := projects[projectID]; exists {
 		update := Update{
-			UpdatedBy: updatedBy, // The user who made the update
+			UpdatedBy: event.Actor, // The user who made the update
+			Event:     event,
 		}
 
 		for _, userChan := range project.Users {
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+)
+
+const (
+	// How often the server pings each connection
+	pingInterval = 25 * time.Second
+
+	// How long a connection may stay silent (no pong, heartbeat or message)
+	// before its presence entry expires and the connection is closed
+	presenceTTL = 75 * time.Second
+)
+
+// PresenceEntry describes what a single connection is doing in a project
+type PresenceEntry struct {
+	ConnID    string    `json:"conn_id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	AvatarURL string    `json:"avatar_url"`
+	PageID    string    `json:"page_id"`
+	Editing   bool      `json:"editing"`
+	Idle      bool      `json:"idle"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// PresenceUpdateData is sent by clients when they open a page, start or stop
+// editing, or become idle
+type PresenceUpdateData struct {
+	PageID  string `json:"page_id"`
+	Editing bool   `json:"editing"`
+	Idle    bool   `json:"idle"`
+}
+
+type PresenceSnapshotData struct {
+	ConnID  string          `json:"conn_id"` // The connection id of the receiver
+	Entries []PresenceEntry `json:"entries"`
+}
+
+type PresenceLeaveData struct {
+	ConnID string `json:"conn_id"`
+	UserID string `json:"user_id"`
+}
+
+// remotePresence is a presence entry of a client connected to another instance
+type remotePresence struct {
+	entry    PresenceEntry
+	origin   string    // Instance the client is connected to
+	received time.Time // Local time of the last diff or sync, avoids trusting other clocks
+}
+
+// newPresenceEntry looks up the display details of the user for a new connection
+func newPresenceEntry(connID, userID string) PresenceEntry {
+	entry := PresenceEntry{
+		ConnID:   connID,
+		UserID:   userID,
+		LastSeen: time.Now().UTC(),
+	}
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT COALESCE(name, ''), COALESCE(avatar_url, '') FROM users WHERE id = $1
+	`, userID).Scan(&entry.Name, &entry.AvatarURL)
+	if err != nil {
+		fmt.Println("Error getting user details for presence:", err)
+	}
+
+	return entry
+}
+
+// joinPresence adds the entry of a new connection and returns the presence
+// snapshot of the room including the new entry
+func (r *Room) joinPresence(client *Client, entry PresenceEntry) []PresenceEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.presence[client] = &entry
+
+	snapshot := make([]PresenceEntry, 0, len(r.presence)+len(r.remote))
+	for _, e := range r.presence {
+		snapshot = append(snapshot, *e)
+	}
+	for _, rp := range r.remote {
+		snapshot = append(snapshot, rp.entry)
+	}
+	return snapshot
+}
+
+// movePresence applies a client update, it reports false when nothing changed
+func (r *Room) movePresence(client *Client, update PresenceUpdateData) (PresenceEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, exists := r.presence[client]
+	if !exists {
+		return PresenceEntry{}, false
+	}
+
+	entry.LastSeen = time.Now().UTC()
+
+	if entry.PageID == update.PageID && entry.Editing == update.Editing && entry.Idle == update.Idle {
+		return *entry, false
+	}
+
+	entry.PageID = update.PageID
+	entry.Editing = update.Editing
+	entry.Idle = update.Idle
+
+	return *entry, true
+}
+
+// touchPresence records that the connection is still alive
+func (r *Room) touchPresence(client *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, exists := r.presence[client]; exists {
+		entry.LastSeen = time.Now().UTC()
+	}
+}
+
+// publishPresence sends a presence diff to every client of the project on
+// every instance except the connection it is about
+func publishPresence(projectID string, client *Client, event Event) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		fmt.Println("Error marshaling event:", err)
+		return
+	}
+
+	publishRealtime(RealtimeMessage{
+		Kind:      realtimeKindPresence,
+		ProjectID: projectID,
+		SkipConn:  client.connID,
+		Payload:   message,
+	})
+}
+
+// applyRemotePresence keeps track of a presence diff published by another
+// instance so snapshots sent from here include its clients
+func (r *Room) applyRemotePresence(origin string, payload []byte) {
+	var event struct {
+		Type EventType       `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		fmt.Println("Error decoding remote presence:", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch event.Type {
+	case EventPresenceJoin, EventPresenceMove:
+		var entry PresenceEntry
+		if err := json.Unmarshal(event.Data, &entry); err != nil {
+			fmt.Println("Error decoding remote presence:", err)
+			return
+		}
+		r.remote[entry.ConnID] = &remotePresence{entry: entry, origin: origin, received: time.Now().UTC()}
+	case EventPresenceLeave:
+		var leave PresenceLeaveData
+		if err := json.Unmarshal(event.Data, &leave); err != nil {
+			fmt.Println("Error decoding remote presence:", err)
+			return
+		}
+		delete(r.remote, leave.ConnID)
+	}
+}
+
+// applyRemotePresenceSync replaces everything known about the clients of
+// another instance with the full list it published
+func (r *Room) applyRemotePresenceSync(origin string, payload []byte) {
+	var entries []PresenceEntry
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		fmt.Println("Error decoding presence sync:", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for connID, rp := range r.remote {
+		if rp.origin == origin {
+			delete(r.remote, connID)
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		r.remote[entry.ConnID] = &remotePresence{entry: entry, origin: origin, received: now}
+	}
+}
+
+// publishPresenceSync sends the presence of the local clients to the other instances
+func (r *Room) publishPresenceSync(projectID string) {
+	r.mu.Lock()
+	entries := make([]PresenceEntry, 0, len(r.presence))
+	for _, e := range r.presence {
+		entries = append(entries, *e)
+	}
+	r.mu.Unlock()
+
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		fmt.Println("Error marshaling presence sync:", err)
+		return
+	}
+
+	publishRealtime(RealtimeMessage{
+		Kind:      realtimeKindPresenceSync,
+		ProjectID: projectID,
+		Payload:   payload,
+	})
+}
+
+// PublishPresenceSync periodically refreshes the presence other instances
+// hold for our clients, entries that stop being refreshed expire there
+func PublishPresenceSync() {
+	roomsMu.Lock()
+	allRooms := make(map[string]*Room, len(rooms))
+	for projectID, room := range rooms {
+		allRooms[projectID] = room
+	}
+	roomsMu.Unlock()
+
+	for projectID, room := range allRooms {
+		room.publishPresenceSync(projectID)
+	}
+}
+
+// ExpireStalePresence closes connections that stopped answering pings and
+// heartbeats, their leave diff is sent when the read loop ends
+func ExpireStalePresence() {
+	roomsMu.Lock()
+	allRooms := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		allRooms = append(allRooms, room)
+	}
+	roomsMu.Unlock()
+
+	threshold := time.Now().UTC().Add(-presenceTTL)
+
+	for _, room := range allRooms {
+		room.mu.Lock()
+		for client, entry := range room.presence {
+			if entry.LastSeen.Before(threshold) {
+				fmt.Printf("Presence for user %s expired\n", entry.UserID)
+				delete(room.clients, client)
+				client.close()
+			}
+		}
+
+		// Instances that went away stop syncing, forget their clients
+		for connID, rp := range room.remote {
+			if rp.received.Before(threshold) {
+				delete(room.remote, connID)
+			}
+		}
+		room.mu.Unlock()
+	}
+}
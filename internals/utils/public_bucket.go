@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// publicBucket holds everything served by the public docs routes
+const publicBucket = "public-docs"
+
+// maxDeleteBatch is the most keys a DeleteObjects call takes
+const maxDeleteBatch = 1000
+
+var ErrPublicObjectNotFound = errors.New("public object not found")
+
+// ReadPublicObject reads an object of the public docs bucket
+func ReadPublicObject(key string) ([]byte, error) {
+	result, err := initializer.R2Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(publicBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrPublicObjectNotFound
+		}
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	return io.ReadAll(result.Body)
+}
+
+// putPublicObject writes an object of the public docs bucket, a single put
+// replaces the object at once so readers see the old or the new content
+func putPublicObject(key string, content []byte) error {
+	_, err := initializer.R2Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(publicBucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	return err
+}
+
+// deletePublishedObjects deletes keys of the public docs bucket
+func deletePublishedObjects(keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(keys))
+
+		var objects []types.ObjectIdentifier
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		result, err := initializer.R2Client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(publicBucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete published objects: %w", err)
+		}
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("failed to delete %s: %s", aws.ToString(result.Errors[0].Key), aws.ToString(result.Errors[0].Message))
+		}
+	}
+
+	return nil
+}
+
+// DeletePublicPrefix deletes every object under prefix from the public docs
+// bucket, except the ones keep returns true for. It returns how many were
+// deleted.
+func DeletePublicPrefix(prefix string, keep func(key string) bool) (int, error) {
+	// Without the slash "docs" would also match "docs-v2"
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(initializer.R2Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(publicBucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return 0, fmt.Errorf("failed to list published objects: %w", err)
+		}
+		for _, object := range page.Contents {
+			if keep != nil && keep(aws.ToString(object.Key)) {
+				continue
+			}
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+
+	if err := deletePublishedObjects(keys); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,415 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Every publish is a deploy written to <slug>/deploys/<id>/ and never changed
+// after. Its manifest <slug>/deploys/<id>.json lists every published path
+// with the sha256 of its content and the deploy holding it, so files that
+// didn't change stay in the deploy that uploaded them. <slug>/current.json
+// points to the deploy readers are served from and is switched with a single
+// put once a deploy is fully uploaded.
+
+type DeployMode int
+
+const (
+	DeployFull        DeployMode = iota // The files are the whole docs, unchanged ones aren't uploaded again
+	DeployFresh                         // The whole docs, every file uploaded again
+	DeployIncremental                   // Changes on top of the current deploy
+)
+
+// keepDeploys is how many of the latest deploys are kept to roll back to
+const keepDeploys = 10
+
+// pointerTTL is how long an instance trusts the pointer it read, switches
+// made by other instances show up after at most this long
+const pointerTTL = 5 * time.Second
+
+var ErrNoCurrentDeploy = errors.New("docs have no current deploy")
+
+type ManifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Deploy string `json:"deploy"`
+}
+
+type PublishManifest struct {
+	ID        string                   `json:"id"`
+	Parent    string                   `json:"parent,omitempty"`
+	Commit    string                   `json:"commit,omitempty"` // Repo commit the docs were built from, empty when unknown
+	CreatedAt time.Time                `json:"created_at"`
+	Files     map[string]ManifestEntry `json:"files"`
+}
+
+type currentPointer struct {
+	Deploy    string    `json:"deploy"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func deployPrefix(slug, id string) string {
+	return slug + "/deploys/" + id
+}
+
+func manifestKey(slug, id string) string {
+	return slug + "/deploys/" + id + ".json"
+}
+
+func currentPointerKey(slug string) string {
+	return slug + "/current.json"
+}
+
+var pointerCache = struct {
+	sync.Mutex
+	entries map[string]currentPointer // UpdatedAt is when it was read
+}{entries: map[string]currentPointer{}}
+
+// Manifests never change, they are kept until there are too many
+var manifestCache = struct {
+	sync.Mutex
+	entries map[string]*PublishManifest
+}{entries: map[string]*PublishManifest{}}
+
+// readCurrentDeploy reads the pointer from the bucket, "" for docs published
+// before deploys or not published at all
+func readCurrentDeploy(slug string) (string, error) {
+	data, err := ReadPublicObject(currentPointerKey(slug))
+	if err == ErrPublicObjectNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var pointer currentPointer
+	if err := json.Unmarshal(data, &pointer); err != nil {
+		return "", fmt.Errorf("invalid current pointer of %s: %w", slug, err)
+	}
+
+	return pointer.Deploy, nil
+}
+
+// CurrentDeploy returns the deploy the docs under slug are served from
+func CurrentDeploy(slug string) (string, error) {
+	pointerCache.Lock()
+	cached, ok := pointerCache.entries[slug]
+	pointerCache.Unlock()
+	if ok && time.Since(cached.UpdatedAt) < pointerTTL {
+		return cached.Deploy, nil
+	}
+
+	id, err := readCurrentDeploy(slug)
+	if err != nil {
+		return "", err
+	}
+
+	pointerCache.Lock()
+	pointerCache.entries[slug] = currentPointer{Deploy: id, UpdatedAt: time.Now()}
+	pointerCache.Unlock()
+
+	return id, nil
+}
+
+// ForgetCurrentDeploy drops the cached pointer of the slug
+func ForgetCurrentDeploy(slug string) {
+	pointerCache.Lock()
+	delete(pointerCache.entries, slug)
+	pointerCache.Unlock()
+}
+
+// LoadManifest reads the manifest of a deploy
+func LoadManifest(slug, id string) (*PublishManifest, error) {
+	key := manifestKey(slug, id)
+
+	manifestCache.Lock()
+	cached, ok := manifestCache.entries[key]
+	manifestCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	data, err := ReadPublicObject(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest PublishManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", key, err)
+	}
+
+	manifestCache.Lock()
+	if len(manifestCache.entries) >= 256 {
+		manifestCache.entries = map[string]*PublishManifest{}
+	}
+	manifestCache.entries[key] = &manifest
+	manifestCache.Unlock()
+
+	return &manifest, nil
+}
+
+// ReadPublishedFile reads a file of the published docs through the current
+// pointer. Docs published before deploys are read from their flat layout.
+func ReadPublishedFile(slug, path string) ([]byte, error) {
+	id, err := CurrentDeploy(slug)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return ReadPublicObject(slug + "/" + path)
+	}
+
+	manifest, err := LoadManifest(slug, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := manifest.Files[path]
+	if !ok {
+		return nil, ErrPublicObjectNotFound
+	}
+
+	return ReadPublicObject(deployPrefix(slug, entry.Deploy) + "/" + path)
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Deploy writes the files as a new deploy of the docs and switches readers to
+// it once everything is uploaded, a failed upload leaves the docs as they
+// were. commit is the repo commit the files are from, removed is only used
+// by incremental deploys.
+func Deploy(projectID, slug, commit string, files []FileContent, removed []string, mode DeployMode, run *PublishRun) (*PublishManifest, error) {
+	var base *PublishManifest
+	if mode != DeployFresh {
+		// Read past the cache, an old base would undo the latest deploy
+		currentID, err := readCurrentDeploy(slug)
+		if err != nil {
+			return nil, err
+		}
+		if currentID != "" {
+			base, err = LoadManifest(slug, currentID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if mode == DeployIncremental {
+		if base == nil {
+			return nil, ErrNoCurrentDeploy
+		}
+		if len(files) == 0 && len(removed) == 0 {
+			return base, nil
+		}
+	}
+
+	manifest := &PublishManifest{
+		ID:        uuid.NewString(),
+		Commit:    commit,
+		CreatedAt: time.Now().UTC(),
+		Files:     map[string]ManifestEntry{},
+	}
+
+	if base != nil {
+		manifest.Parent = base.ID
+	}
+
+	if mode == DeployIncremental {
+		for path, entry := range base.Files {
+			manifest.Files[path] = entry
+		}
+		for _, path := range removed {
+			delete(manifest.Files, path)
+		}
+	}
+
+	var uploads []FileContent
+	for _, file := range files {
+		hash := contentHash(file.Content)
+		if base != nil {
+			if entry, ok := base.Files[file.Path]; ok && entry.SHA256 == hash {
+				manifest.Files[file.Path] = entry
+				continue
+			}
+		}
+
+		manifest.Files[file.Path] = ManifestEntry{SHA256: hash, Deploy: manifest.ID}
+		uploads = append(uploads, file)
+	}
+
+	if err := UploadFiles(uploads, deployPrefix(slug, manifest.ID), run); err != nil {
+		// Nothing points at the half written deploy, don't leave it behind
+		if _, cleanupErr := DeletePublicPrefix(deployPrefix(slug, manifest.ID), nil); cleanupErr != nil {
+			fmt.Println("Error cleaning up failed deploy:", cleanupErr)
+		}
+		return nil, err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := uploadObject(manifestKey(slug, manifest.ID), data); err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+
+	var jobID, createdBy *string
+	if run != nil {
+		jobID = &run.ID
+		if run.UserID != "" {
+			createdBy = &run.UserID
+		}
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		INSERT INTO publish_deploys (id, project_id, slug, parent_id, job_id, created_by, file_count, uploaded_count)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6, $7, $8)
+	`, manifest.ID, projectID, slug, manifest.Parent, jobID, createdBy, len(manifest.Files), len(uploads))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record deploy: %w", err)
+	}
+
+	if err := SwitchDeploy(projectID, slug, manifest.ID); err != nil {
+		return nil, err
+	}
+
+	if err := pruneDeploys(projectID, slug, manifest.ID); err != nil {
+		fmt.Println("Error pruning old deploys:", err)
+	}
+
+	return manifest, nil
+}
+
+// SwitchDeploy points the docs at a deploy. It is a single put of the
+// pointer, so readers go from one complete deploy to the other.
+func SwitchDeploy(projectID, slug, id string) error {
+	data, err := json.Marshal(currentPointer{Deploy: id, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	if err := uploadObject(currentPointerKey(slug), data); err != nil {
+		return fmt.Errorf("failed to switch current deploy: %w", err)
+	}
+
+	pointerCache.Lock()
+	pointerCache.entries[slug] = currentPointer{Deploy: id, UpdatedAt: time.Now()}
+	pointerCache.Unlock()
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE projects SET current_deploy_id = $2 WHERE id = $1
+	`, projectID, id)
+	if err != nil {
+		fmt.Println("Error saving current deploy:", err)
+	}
+
+	return nil
+}
+
+// pruneDeploys deletes deploys that are neither among the latest ones nor
+// holding files of them
+func pruneDeploys(projectID, slug, currentID string) error {
+	rows, err := initializer.DB.Query(context.Background(), `
+		SELECT id FROM publish_deploys
+		WHERE project_id = $1 AND slug = $2 AND NOT pruned
+		ORDER BY created_at DESC
+	`, projectID, slug)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	if len(ids) <= keepDeploys {
+		return nil
+	}
+
+	used := map[string]bool{currentID: true}
+	for i, id := range ids {
+		if i >= keepDeploys && id != currentID {
+			continue
+		}
+		manifest, err := LoadManifest(slug, id)
+		if err != nil {
+			// Without its manifest we can't tell what it needs, keep everything
+			return fmt.Errorf("failed to load manifest %s: %w", id, err)
+		}
+		used[id] = true
+		for _, entry := range manifest.Files {
+			used[entry.Deploy] = true
+		}
+	}
+
+	for _, id := range ids[keepDeploys:] {
+		if used[id] {
+			continue
+		}
+
+		if _, err := DeletePublicPrefix(deployPrefix(slug, id), nil); err != nil {
+			return err
+		}
+		if err := deletePublishedObjects([]string{manifestKey(slug, id)}); err != nil {
+			return err
+		}
+
+		_, err := initializer.DB.Exec(context.Background(), `
+			UPDATE publish_deploys SET pruned = true WHERE id = $1
+		`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var ErrDeployNotFound = errors.New("deploy not found or too old to roll back to")
+
+// RollbackDeploy points the docs back at one of their latest deploys. Run it
+// in a publish job, a deploy running next to it would switch the docs back.
+func RollbackDeploy(projectID, id string) error {
+	var slug string
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT slug FROM (
+			SELECT id, slug FROM publish_deploys
+			WHERE project_id = $1 AND NOT pruned
+			ORDER BY created_at DESC
+			LIMIT $3
+		) latest
+		WHERE id::text = $2
+	`, projectID, id, keepDeploys).Scan(&slug)
+	if err == pgx.ErrNoRows {
+		return ErrDeployNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return SwitchDeploy(projectID, slug, id)
+}
+
+// IsDeployKey tells if a key of the bucket belongs to the given deploy
+func IsDeployKey(slug, id, key string) bool {
+	return key == manifestKey(slug, id) || strings.HasPrefix(key, deployPrefix(slug, id)+"/")
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
+)
+
+const (
+	uploadWorkers      = 8
+	maxUploadAttempts  = 4
+	uploadBackoffStart = 500 * time.Millisecond
+)
+
+// PublishRun tracks a publish job while it runs
+type PublishRun struct {
+	ID        string
+	ProjectID string
+	UserID    string
+	Kind      models.PublishJobKind
+
+	total    atomic.Int64
+	uploaded atomic.Int64
+}
+
+// Jobs of a project run one at a time, a republish clearing the bucket while
+// a publish uploads would lose pages
+var publishLocks = struct {
+	sync.Mutex
+	projects map[string]*sync.Mutex
+}{projects: map[string]*sync.Mutex{}}
+
+func publishLock(projectID string) *sync.Mutex {
+	publishLocks.Lock()
+	defer publishLocks.Unlock()
+
+	lock, ok := publishLocks.projects[projectID]
+	if !ok {
+		lock = &sync.Mutex{}
+		publishLocks.projects[projectID] = lock
+	}
+	return lock
+}
+
+// lockPublishing holds the project for one job across every instance until
+// unlock is called. The mutex keeps jobs of this instance from each taking a
+// connection while they wait.
+func lockPublishing(projectID string) (unlock func(), err error) {
+	lock := publishLock(projectID)
+	lock.Lock()
+
+	tx, err := initializer.DB.Begin(context.Background())
+	if err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to lock publishing: %w", err)
+	}
+
+	_, err = tx.Exec(context.Background(), `SELECT pg_advisory_xact_lock(hashtext($1))`, "publish:"+projectID)
+	if err != nil {
+		tx.Rollback(context.Background())
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to lock publishing: %w", err)
+	}
+
+	return func() {
+		tx.Rollback(context.Background())
+		lock.Unlock()
+	}, nil
+}
+
+// StopPublishing runs stop once no job of the project is running and cancels
+// the queued ones, they would bring back what stop takes down
+func StopPublishing(projectID string, stop func() error) error {
+	unlock, err := lockPublishing(projectID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE publish_jobs SET status = $2, finished_at = now()
+		WHERE project_id::text = $1 AND status = $3
+	`, projectID, models.PublishCanceled, models.PublishQueued)
+	if err != nil {
+		return fmt.Errorf("failed to cancel publish jobs: %w", err)
+	}
+
+	return stop()
+}
+
+// QueuePublishJob records a queued publish job, start it with Run
+func QueuePublishJob(projectID, userID string, kind models.PublishJobKind) (*PublishRun, error) {
+	var createdBy *string
+	if userID != "" {
+		createdBy = &userID
+	}
+
+	run := &PublishRun{ProjectID: projectID, UserID: userID, Kind: kind}
+	err := initializer.DB.QueryRow(context.Background(), `
+		INSERT INTO publish_jobs (project_id, kind, status, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, projectID, kind, models.PublishQueued, createdBy).Scan(&run.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// Run runs the job once no other job of the project is running and saves how
+// it went. Clients connected to the project get an event when it is done.
+func (r *PublishRun) Run(job func(run *PublishRun) error) error {
+	unlock, err := lockPublishing(r.ProjectID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// The docs may have been unpublished while the job waited
+	result, err := initializer.DB.Exec(context.Background(), `
+		UPDATE publish_jobs SET status = $2, started_at = now() WHERE id = $1 AND status = $3
+	`, r.ID, models.PublishRunning, models.PublishQueued)
+	if err != nil {
+		return fmt.Errorf("failed to start publish job: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		BroadcastProjectEvent(r.ProjectID, r.UserID, EventPublishJob, PublishJobData{
+			ID:     r.ID,
+			Kind:   string(r.Kind),
+			Status: string(models.PublishCanceled),
+		})
+		return nil
+	}
+
+	err = job(r)
+
+	status, message := models.PublishSucceeded, ""
+	if err != nil {
+		status, message = models.PublishFailed, err.Error()
+		fmt.Printf("Publish job %s failed: %v\n", r.ID, err)
+	}
+	r.setStatus(status, message)
+
+	BroadcastProjectEvent(r.ProjectID, r.UserID, EventPublishJob, PublishJobData{
+		ID:            r.ID,
+		Kind:          string(r.Kind),
+		Status:        string(status),
+		Error:         message,
+		TotalFiles:    int(r.total.Load()),
+		UploadedFiles: int(r.uploaded.Load()),
+	})
+
+	return err
+}
+
+func (r *PublishRun) setStatus(status models.PublishJobStatus, message string) {
+	_, err := initializer.DB.Exec(context.Background(), `
+		UPDATE publish_jobs
+		SET status = $2,
+			error = $3,
+			total_files = $4,
+			uploaded_files = $5,
+			started_at = CASE WHEN $2 = 'running' THEN now() ELSE started_at END,
+			finished_at = CASE WHEN $2 IN ('failed', 'succeeded') THEN now() ELSE finished_at END
+		WHERE id = $1
+	`, r.ID, status, message, r.total.Load(), r.uploaded.Load())
+	if err != nil {
+		fmt.Println("Error updating publish job:", err)
+	}
+}
+
+// saveProgress keeps the file counts of the job up to date for the status endpoint
+func (r *PublishRun) saveProgress() {
+	_, err := initializer.DB.Exec(context.Background(), `
+		UPDATE publish_jobs SET total_files = $2, uploaded_files = $3 WHERE id = $1
+	`, r.ID, r.total.Load(), r.uploaded.Load())
+	if err != nil {
+		fmt.Println("Error updating publish job progress:", err)
+	}
+}
+
+// uploadObject puts one object in the public docs bucket, retrying with a
+// growing backoff
+func uploadObject(key string, content []byte) error {
+	backoff := uploadBackoffStart
+
+	var err error
+	for attempt := 1; attempt <= maxUploadAttempts; attempt++ {
+		err = putPublicObject(key, content)
+		if err == nil {
+			return nil
+		}
+
+		if attempt < maxUploadAttempts {
+			log.Printf("Upload of %s failed (attempt %d), retrying in %s: %v", key, attempt, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return err
+}
+
+// UploadFiles uploads the files under prefix, a few at a time. Files that
+// still fail after their retries are listed in the returned error. run can be
+// nil when the upload isn't part of a job.
+func UploadFiles(contents []FileContent, prefix string, run *PublishRun) error {
+	if run != nil {
+		run.total.Add(int64(len(contents)))
+		run.saveProgress()
+	}
+
+	files := make(chan FileContent)
+	var failed []string
+	var failedLock sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < min(uploadWorkers, len(contents)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				if err := uploadObject(prefix+"/"+file.Path, []byte(file.Content)); err != nil {
+					log.Printf("Failed to upload %s: %v", file.Path, err)
+					failedLock.Lock()
+					failed = append(failed, file.Path)
+					failedLock.Unlock()
+					continue
+				}
+
+				if run != nil {
+					run.uploaded.Add(1)
+					run.saveProgress()
+				}
+			}
+		}()
+	}
+
+	for _, file := range contents {
+		files <- file
+	}
+	close(files)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to upload %d of %d files: %s", len(failed), len(contents), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+)
+
+// A preview is an editing branch published to <slug>/previews/<token>/. The
+// token is the only way to find it, previews aren't listed anywhere public.
+// They are deleted once the branch is merged or deleted.
+
+const previewTokenBytes = 24
+
+func previewPrefix(slug, token string) string {
+	return slug + "/previews/" + token
+}
+
+// NewPreviewToken returns a random token for the url of a preview
+func NewPreviewToken() (string, error) {
+	buf := make([]byte, previewTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func validPreviewToken(token string) bool {
+	if len(token) != previewTokenBytes*2 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// UploadPreview uploads the files of a preview and deletes the ones left
+// from its last upload, pages removed on the branch go away with them
+func UploadPreview(slug, token string, files []FileContent, run *PublishRun) error {
+	prefix := previewPrefix(slug, token)
+
+	if err := UploadFiles(files, prefix, run); err != nil {
+		return err
+	}
+
+	uploaded := map[string]bool{}
+	for _, file := range files {
+		uploaded[prefix+"/"+file.Path] = true
+	}
+
+	_, err := DeletePublicPrefix(prefix, func(key string) bool {
+		return uploaded[key]
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clear old preview files: %w", err)
+	}
+
+	return nil
+}
+
+// ReadPreviewFile reads a file of a live preview, unknown and expired tokens
+// read as not found
+func ReadPreviewFile(slug, token, path string) ([]byte, error) {
+	if !validPreviewToken(token) {
+		return nil, ErrPublicObjectNotFound
+	}
+
+	var live bool
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT EXISTS (
+			SELECT 1 FROM previews WHERE token = $1 AND slug = $2 AND expired_at IS NULL
+		)
+	`, token, slug).Scan(&live)
+	if err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, ErrPublicObjectNotFound
+	}
+
+	return ReadPublicObject(previewPrefix(slug, token) + "/" + path)
+}
+
+// ExpirePreviews takes down the previews of a branch. They stop being served
+// right away, the files are deleted once a preview job still uploading them
+// is done.
+func ExpirePreviews(projectID, branchName string) error {
+	rows, err := initializer.DB.Query(context.Background(), `
+		UPDATE previews SET expired_at = now()
+		WHERE project_id::text = $1 AND branch_name = $2 AND expired_at IS NULL
+		RETURNING slug, token
+	`, projectID, branchName)
+	if err != nil {
+		return err
+	}
+
+	var prefixes []string
+	for rows.Next() {
+		var slug, token string
+		if err := rows.Scan(&slug, &token); err == nil {
+			prefixes = append(prefixes, previewPrefix(slug, token))
+		}
+	}
+	rows.Close()
+
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	unlock, err := lockPublishing(projectID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, prefix := range prefixes {
+		if _, err := DeletePublicPrefix(prefix, nil); err != nil {
+			return fmt.Errorf("failed to delete preview %s: %w", prefix, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// zeroSha is the before of a push that creates a branch and the after of one
+// that deletes it
+const zeroSha = "0000000000000000000000000000000000000000"
+
+// PublicPath maps a path of the docs repo to its key under the slug of the
+// published docs. The tree is published as folder.json and pages keep their
+// path below Documentthing/files. Anything else isn't published.
+func PublicPath(repoPath string) (string, bool) {
+	if repoPath == "Documentthing/folder/folder.json" {
+		return "folder.json", true
+	}
+
+	rest, ok := strings.CutPrefix(repoPath, "Documentthing/files/")
+	if !ok || !strings.HasSuffix(rest, ".json") {
+		return "", false
+	}
+
+	// A page published as folder.json would replace the tree
+	if rest == "folder.json" {
+		return "", false
+	}
+
+	return rest, true
+}
+
+// PublishChanges is what a push changed in the published docs, as repo paths
+// with the blob sha they have after the push
+type PublishChanges struct {
+	Upload map[string]string
+	Remove []string
+}
+
+// githubTreeBlobs returns the blobs of the tree of a commit by path
+func githubTreeBlobs(owner, repo, sha, token string) (map[string]string, error) {
+	blobs := map[string]string{}
+	if sha == "" || sha == zeroSha {
+		return blobs, nil
+	}
+
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees/%s?recursive=1", owner, repo, sha)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get tree of %s: %s", sha, resp.Status)
+	}
+
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+			Sha  string `json:"sha"`
+		} `json:"tree"`
+		Truncated bool `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	// A partial tree would make missing pages look deleted
+	if tree.Truncated {
+		return nil, fmt.Errorf("tree of %s is too big to diff", sha)
+	}
+
+	for _, entry := range tree.Tree {
+		if entry.Type == "blob" {
+			blobs[entry.Path] = entry.Sha
+		}
+	}
+
+	return blobs, nil
+}
+
+// DiffPush compares the trees before and after a push. Every commit of the
+// push is covered as only the end states matter, a rename shows up as a
+// removed and an uploaded path.
+func DiffPush(owner, repo, before, after, token string) (PublishChanges, error) {
+	changes := PublishChanges{Upload: map[string]string{}}
+
+	oldBlobs, err := githubTreeBlobs(owner, repo, before, token)
+	if err != nil {
+		return changes, err
+	}
+
+	newBlobs, err := githubTreeBlobs(owner, repo, after, token)
+	if err != nil {
+		return changes, err
+	}
+
+	for path, sha := range newBlobs {
+		if _, ok := PublicPath(path); ok && oldBlobs[path] != sha {
+			changes.Upload[path] = sha
+		}
+	}
+
+	for path := range oldBlobs {
+		if _, ok := PublicPath(path); ok {
+			if _, exists := newBlobs[path]; !exists {
+				changes.Remove = append(changes.Remove, path)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// fetchBlobContent returns a blob as the base64 string the contents api
+// gives, which is how pages are published
+func fetchBlobContent(owner, repo, sha, token string) (string, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/blobs/%s", owner, repo, sha)
+	return fetchFileContent(url, token)
+}
+
+// EmbeddedDrawings returns the drawings embedded in pages of a project keyed
+// by the path they are published under, set by the controller which knows
+// how to read them
+var EmbeddedDrawings func(projectID string, pages []FileContent) []FileContent
+
+// withDrawings adds the drawings embedded in the pages to the upload
+func withDrawings(projectID string, contents []FileContent) []FileContent {
+	if EmbeddedDrawings == nil {
+		return contents
+	}
+	return append(contents, EmbeddedDrawings(projectID, contents)...)
+}
+
+// githubGetJSON does an authenticated GET on the GitHub API and decodes the response
+func githubGetJSON(url, token string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return nil
+}
+
+// githubCompareStatus tells how head relates to base: ahead, behind,
+// identical or diverged
+func githubCompareStatus(owner, repo, base, head, token string) (string, error) {
+	var compare struct {
+		Status string `json:"status"`
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/compare/%s...%s", owner, repo, base, head)
+	if err := githubGetJSON(url, token, &compare); err != nil {
+		return "", err
+	}
+	return compare.Status, nil
+}
+
+// githubBranchHead returns the commit a branch points at
+func githubBranchHead(owner, repo, branch, token string) (string, error) {
+	var ref struct {
+		Object struct {
+			Sha string `json:"sha"`
+		} `json:"object"`
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/ref/heads/%s", owner, repo, branch)
+	if err := githubGetJSON(url, token, &ref); err != nil {
+		return "", err
+	}
+	return ref.Object.Sha, nil
+}
+
+// SyncPublishedDocs brings the published docs of a repo in line with a push
+// to its default branch. The pages are diffed from the commit the current
+// deploy was built from rather than the before of the push, so a push whose
+// sync failed goes out with the next one and a replayed old delivery can't
+// roll pages back. Pushes the docs are already at or past are skipped.
+func SyncPublishedDocs(projectID, owner, repo, slug, branch, after, token string, run *PublishRun) error {
+	var base *PublishManifest
+	currentID, err := readCurrentDeploy(slug)
+	if err != nil {
+		return err
+	}
+	if currentID != "" {
+		base, err = LoadManifest(slug, currentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// No deploy or one that doesn't know its commit has nothing to diff from
+	status := "unknown"
+	if base != nil && base.Commit != "" {
+		status, err = githubCompareStatus(owner, repo, base.Commit, after, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch status {
+	case "ahead":
+		return syncChanges(projectID, owner, repo, slug, base.Commit, after, token, run)
+	case "identical", "behind":
+		fmt.Printf("Published docs of %s are already at or past %s, skipping\n", slug, after)
+		return nil
+	}
+
+	// Publishing the whole tree is only right for the latest push, history
+	// may have been rewritten
+	head, err := githubBranchHead(owner, repo, branch, token)
+	if err != nil {
+		return err
+	}
+	if head != after {
+		fmt.Printf("Skipping sync of %s, %s is no longer the head of %s\n", slug, after, branch)
+		return nil
+	}
+
+	changes, err := DiffPush(owner, repo, zeroSha, after, token)
+	if err != nil {
+		return err
+	}
+
+	contents, err := fetchChanges(owner, repo, changes, token)
+	if err != nil {
+		return err
+	}
+
+	if _, err := Deploy(projectID, slug, after, withDrawings(projectID, contents), nil, DeployFull, run); err != nil {
+		return err
+	}
+
+	fmt.Printf("Published docs of %s from %s: %d pages\n", slug, after, len(contents))
+	return nil
+}
+
+// syncChanges deploys what changed between the commit of the current deploy
+// and after on top of it
+func syncChanges(projectID, owner, repo, slug, from, after, token string, run *PublishRun) error {
+	changes, err := DiffPush(owner, repo, from, after, token)
+	if err != nil {
+		return err
+	}
+
+	contents, err := fetchChanges(owner, repo, changes, token)
+	if err != nil {
+		return err
+	}
+
+	var removed []string
+	for _, repoPath := range changes.Remove {
+		key, _ := PublicPath(repoPath)
+		removed = append(removed, key)
+	}
+
+	if _, err := Deploy(projectID, slug, after, withDrawings(projectID, contents), removed, DeployIncremental, run); err != nil {
+		return err
+	}
+
+	fmt.Printf("Synced published docs of %s: %d changed, %d removed\n", slug, len(contents), len(removed))
+	return nil
+}
+
+// fetchChanges reads the content of the changed pages, keyed by their public path
+func fetchChanges(owner, repo string, changes PublishChanges, token string) ([]FileContent, error) {
+	var contents []FileContent
+	for repoPath, sha := range changes.Upload {
+		content, err := fetchBlobContent(owner, repo, sha, token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", repoPath, err)
+		}
+
+		key, _ := PublicPath(repoPath)
+		contents = append(contents, FileContent{Path: key, Content: content})
+	}
+
+	return contents, nil
+}
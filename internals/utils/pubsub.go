@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/google/uuid"
+)
+
+// Kinds of messages exchanged between instances
+const (
+	realtimeKindEvent          = "event"           // Project event, delivered to every client
+	realtimeKindPresence       = "presence"        // Presence diff, delivered to every client
+	realtimeKindPresenceSync   = "presence_sync"   // Full presence of one instance, not delivered
+	realtimeKindPresenceRefill = "presence_refill" // Asks every instance to sync a project now
+	realtimeKindDisconnect     = "disconnect"      // Close the connections of a user
+)
+
+// Postgres rejects NOTIFY payloads of 8000 bytes or more
+const maxNotifyPayload = 7900
+
+// InstanceID identifies this process among the replicas behind the load balancer
+var InstanceID = uuid.NewString()
+
+// RealtimeMessage is what travels through the pub/sub layer
+type RealtimeMessage struct {
+	Kind      string          `json:"kind"`
+	ProjectID string          `json:"project_id"`
+	Origin    string          `json:"origin"`              // Instance that published the message
+	SkipConn  string          `json:"skip_conn,omitempty"` // Connection that must not receive the message
+	UserID    string          `json:"user_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// PubSub fans realtime messages out to every instance, including the one
+// that published them, so any instance can deliver to any client
+type PubSub interface {
+	Publish(ctx context.Context, msg RealtimeMessage) error
+	Start(handler func(RealtimeMessage)) error
+}
+
+// LocalPubSub delivers messages inside this process only, for single
+// instance deployments
+type LocalPubSub struct {
+	handler func(RealtimeMessage)
+}
+
+func (p *LocalPubSub) Start(handler func(RealtimeMessage)) error {
+	p.handler = handler
+	return nil
+}
+
+func (p *LocalPubSub) Publish(ctx context.Context, msg RealtimeMessage) error {
+	if p.handler != nil {
+		p.handler(msg)
+	}
+	return nil
+}
+
+// PostgresPubSub uses LISTEN/NOTIFY on the application database
+type PostgresPubSub struct {
+	Channel string
+	handler func(RealtimeMessage)
+}
+
+func (p *PostgresPubSub) Start(handler func(RealtimeMessage)) error {
+	p.handler = handler
+	go p.listen()
+	return nil
+}
+
+func (p *PostgresPubSub) Publish(ctx context.Context, msg RealtimeMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		return fmt.Errorf("realtime message of %d bytes is too large for NOTIFY", len(payload))
+	}
+
+	_, err = initializer.DB.Exec(ctx, `SELECT pg_notify($1, $2)`, p.Channel, string(payload))
+	return err
+}
+
+// listen keeps a dedicated connection listening and reconnects when it drops
+func (p *PostgresPubSub) listen() {
+	backoff := time.Second
+
+	for {
+		err := p.listenOnce()
+		log.Printf("Realtime listener stopped: %v, reconnecting in %s", err, backoff)
+
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (p *PostgresPubSub) listenOnce() error {
+	ctx := context.Background()
+
+	pooled, err := initializer.DB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Take the connection out of the pool so the LISTEN state never leaks
+	conn := pooled.Hijack()
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, `LISTEN "`+p.Channel+`"`); err != nil {
+		return err
+	}
+
+	log.Printf("Realtime listener started on channel %s", p.Channel)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var msg RealtimeMessage
+		if err := json.Unmarshal([]byte(notification.Payload), &msg); err != nil {
+			log.Printf("Invalid realtime message: %v", err)
+			continue
+		}
+
+		p.handler(msg)
+	}
+}
+
+var realtime PubSub = &LocalPubSub{}
+
+// InitRealtime picks the pub/sub implementation, Postgres by default so
+// several replicas can share rooms. Set REALTIME_PUBSUB=local to keep
+// everything inside one process.
+func InitRealtime() {
+	if os.Getenv("REALTIME_PUBSUB") == "local" {
+		realtime = &LocalPubSub{}
+	} else {
+		realtime = &PostgresPubSub{Channel: "documentthing_realtime"}
+	}
+
+	if err := realtime.Start(deliverRealtimeMessage); err != nil {
+		log.Fatal("Failed to start realtime pub/sub:", err)
+	}
+}
+
+// publishRealtime sends a message to every instance, when the pub/sub layer
+// fails the message is still delivered to the clients of this instance
+func publishRealtime(msg RealtimeMessage) {
+	msg.Origin = InstanceID
+
+	if err := realtime.Publish(context.Background(), msg); err != nil {
+		fmt.Println("Error publishing realtime message:", err)
+		deliverRealtimeMessage(msg)
+	}
+}
+
+// deliverRealtimeMessage hands a message received from any instance to the
+// clients connected to this one
+func deliverRealtimeMessage(msg RealtimeMessage) {
+	switch msg.Kind {
+	case realtimeKindEvent:
+		if room := getRoom(msg.ProjectID); room != nil {
+			room.broadcast(msg.Payload, msg.SkipConn)
+		}
+
+		var event Event
+		if err := json.Unmarshal(msg.Payload, &event); err == nil {
+			NotifyUsers(msg.ProjectID, event)
+		}
+
+	case realtimeKindPresence:
+		room := getRoom(msg.ProjectID)
+		if room == nil {
+			return
+		}
+
+		if msg.Origin != InstanceID {
+			room.applyRemotePresence(msg.Origin, msg.Payload)
+		}
+		room.broadcast(msg.Payload, msg.SkipConn)
+
+	case realtimeKindPresenceSync:
+		if msg.Origin == InstanceID {
+			return
+		}
+
+		if room := getRoom(msg.ProjectID); room != nil {
+			room.applyRemotePresenceSync(msg.Origin, msg.Payload)
+		}
+
+	case realtimeKindPresenceRefill:
+		if msg.Origin == InstanceID {
+			return
+		}
+
+		if room := getRoom(msg.ProjectID); room != nil {
+			room.publishPresenceSync(msg.ProjectID)
+		}
+
+	case realtimeKindDisconnect:
+		disconnectLocalUser(msg.ProjectID, msg.UserID)
+	}
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+)
+
+// PullRequestPayload is the part of the pull_request webhook we use
+type PullRequestPayload struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+		Merged bool   `json:"merged"`
+		Head   struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+// handlePullRequestEvent keeps the review requests in sync with pull
+// requests merged, closed, reopened or edited on GitHub
+func handlePullRequestEvent(body []byte) error {
+	var payload PullRequestPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return err
+	}
+
+	var status string
+	switch payload.Action {
+	case "closed":
+		status = "closed"
+		if payload.PullRequest.Merged {
+			status = "merged"
+		}
+	case "reopened":
+		status = "open"
+	case "edited":
+	default:
+		return nil
+	}
+
+	var reviewID, projectID, branchName, currentStatus string
+
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT rr.id, rr.project_id, rr.branch_name, rr.status
+		FROM review_requests rr
+		JOIN projects p ON p.id = rr.project_id
+		WHERE p.name = $1 AND rr.pr_number = $2
+			AND LOWER(COALESCE(NULLIF(p.org, ''), p.repo_owner)) = LOWER($3)
+		ORDER BY rr.created_at DESC
+		LIMIT 1
+	`, payload.Repository.Name, payload.PullRequest.Number, payload.Repository.Owner.Login).Scan(&reviewID, &projectID, &branchName, &currentStatus)
+	if err != nil {
+		// Pull requests opened outside the app, or in a same named repo of
+		// someone else, aren't tracked
+		return nil
+	}
+
+	if payload.Action == "edited" {
+		_, err = initializer.DB.Exec(context.Background(), `
+			UPDATE review_requests SET title = $2, description = $3, updated_at = now() WHERE id = $1
+		`, reviewID, payload.PullRequest.Title, payload.PullRequest.Body)
+		return err
+	}
+
+	// A review merged in the app stays merged when GitHub reports the PR closed
+	if currentStatus == "merged" {
+		return nil
+	}
+
+	_, err = initializer.DB.Exec(context.Background(), `UPDATE review_requests SET status = $2, updated_at = now() WHERE id = $1`, reviewID, status)
+	if err != nil {
+		return err
+	}
+
+	// Keep the editing branch in step with its pull request
+	branchStatus := map[string]string{"merged": "merged", "closed": "active", "open": "in_review"}[status]
+	_, err = initializer.DB.Exec(context.Background(), `
+		UPDATE editing_branches SET status = $3, updated_at = now()
+		WHERE project_id = $1 AND branch_name = $2 AND status IN ('active', 'in_review')
+	`, projectID, branchName, branchStatus)
+	if err != nil {
+		fmt.Println("Error updating editing branch from pull request:", err)
+	}
+
+	if status == "merged" {
+		if err := ExpirePreviews(projectID, branchName); err != nil {
+			fmt.Println("Error expiring previews of merged branch:", err)
+		}
+	}
+
+	BroadcastProjectEvent(projectID, "", EventReviewUpdated, ReviewData{
+		ReviewID:   reviewID,
+		BranchName: branchName,
+		Status:     status,
+	})
+
+	return nil
+}
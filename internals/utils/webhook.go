@@ -1,21 +1,18 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
-	"path"
 	"strings"
-	"sync"
 
 	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
 	"github.com/Akshdhiwar/simpledocs-backend/internals/models"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 )
 
 func HandleWebhookEvents(ctx *gin.Context) {
@@ -123,8 +120,14 @@ type Commit struct {
 
 type WebhookPayload struct {
 	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
 	Repository struct {
-		Name string `json:"name"`
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+			Name  string `json:"name"`
+		} `json:"owner"`
 	} `json:"repository"`
 	Pusher struct {
 		Username string `json:"name"`
@@ -137,88 +140,141 @@ type WebhookPayload struct {
 }
 
 func HandleGithubWebhook(c *gin.Context) {
-	var payload WebhookPayload
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error parsing JSON"})
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error reading body"})
 		return
 	}
 
-	if payload.Ref == "refs/heads/main" || payload.Ref == "refs/heads/master" {
-		userName := payload.Pusher.Username
-		repoName := payload.Repository.Name
+	if !VerifyGithubSignature(body, c.GetHeader("X-Hub-Signature-256")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
 
-		isPublished := false
+	deliveryID := c.GetHeader("X-GitHub-Delivery")
+	event := c.GetHeader("X-GitHub-Event")
+	if deliveryID == "" || event == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing delivery headers"})
+		return
+	}
 
-		err := initializer.DB.QueryRow(context.Background(), `
-	SELECT is_published
-	FROM public.projects
-	WHERE name = $1;
-		`, repoName).Scan(&isPublished)
+	isNew, err := recordDelivery(deliveryID, event, body)
+	if err != nil {
+		fmt.Println("Error recording webhook delivery:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording delivery"})
+		return
+	}
 
-		if err != nil {
-			fmt.Println("Error while getting data from DB")
-			return
-		}
+	// GitHub retries, a delivery already handled is only acknowledged
+	if !isNew {
+		c.JSON(http.StatusOK, gin.H{"message": "Duplicate delivery"})
+		return
+	}
 
-		if !isPublished {
-			c.Status(http.StatusOK)
-			return
-		}
+	// Syncs run as publish jobs, they can take longer than GitHub waits
+	err = handleGithubEvent(deliveryID, event, body)
+	if err == ErrDeliveryQueued {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+		return
+	}
+	finishDelivery(deliveryID, err)
 
-		allChangedFiles := append(payload.HeadCommit.Added, payload.HeadCommit.Modified...)
-		filteredChangedFiles := filterJSONFiles(allChangedFiles)
-		addedModifiedURLs := generateGitHubURLs(userName, repoName, filteredChangedFiles)
-		// filteredRemovedFiles := filterJSONFiles(payload.HeadCommit.Removed)
+	if err != nil {
+		fmt.Println("Error handling webhook delivery:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-		token, err := getTokenFromName(userName, repoName)
-		if err != nil {
-			fmt.Println("Failed to publish updated docs")
-			return
-		}
+	c.Status(http.StatusOK)
+}
 
-		var r2Contents []FileContent
+// handleGithubEvent acts on a delivery, it runs for new deliveries and replays
+func handleGithubEvent(deliveryID, event string, body []byte) error {
+	switch event {
+	case "pull_request":
+		return handlePullRequestEvent(body)
+	case "push":
+		return handlePushEvent(deliveryID, body)
+	}
 
-		// Fetch contents of individual files
-		for _, item := range addedModifiedURLs {
-			content, err := fetchFileContent(item, token)
-			if err != nil {
-				fmt.Println("Failed to publish updated docs")
-				return
-			}
+	return nil
+}
 
-			// Store the content and path in r2Contents
-			r2Contents = append(r2Contents, FileContent{
-				Path:    extractFilenameFromURL(item), // Capitalize 'Path' and 'Content' for proper struct initialization
-				Content: content,
-			})
-		}
+// handlePushEvent syncs the published docs with pushes to the default branch
+// and expires the previews of deleted branches
+func handlePushEvent(deliveryID string, body []byte) error {
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("error parsing JSON: %w", err)
+	}
 
-		uploadFiles(r2Contents, strings.ToLower(repoName))
+	isMain := payload.Ref == "refs/heads/main" || payload.Ref == "refs/heads/master"
+	deleted := payload.After == zeroSha
 
-		c.Status(http.StatusOK)
-		return
+	// Other branches only matter when they are deleted, for their previews
+	if !isMain && !deleted || !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		return nil
 	}
-	c.Status(http.StatusOK)
-}
 
-func extractFilenameFromURL(url string) string {
-	// Extract the last part of the URL (the filename)
-	filename := path.Base(url)
-	// Ensure it ends with .json
-	if strings.HasSuffix(filename, ".json") {
-		return filename
+	repoName := payload.Repository.Name
+	owner := payload.Repository.Owner.Login
+	if owner == "" {
+		owner = payload.Repository.Owner.Name
+	}
+
+	var projectID, ownerID, slug string
+	isPublished := false
+
+	// Names are only unique per owner, the project has to be of this owner
+	err := initializer.DB.QueryRow(context.Background(), `
+	SELECT id, owner, is_published, COALESCE(published_docs_name, LOWER(name))
+	FROM public.projects
+	WHERE name = $1 AND LOWER(COALESCE(NULLIF(org, ''), repo_owner)) = LOWER($2);
+		`, repoName, owner).Scan(&projectID, &ownerID, &isPublished, &slug)
+
+	// Repos that aren't projects of the owner aren't ours to publish
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error while getting data from DB: %w", err)
 	}
-	return ""
-}
 
-func filterJSONFiles(files []string) []string {
-	var jsonFiles []string
-	for _, file := range files {
-		if strings.HasSuffix(file, ".json") {
-			jsonFiles = append(jsonFiles, file)
+	// Branch deleted, nothing to publish but its previews go
+	if deleted {
+		if isMain {
+			return nil
 		}
+		return ExpirePreviews(projectID, strings.TrimPrefix(payload.Ref, "refs/heads/"))
+	}
+
+	if !isPublished {
+		return nil
+	}
+
+	token, err := getTokenFromName(payload.Pusher.Username, repoName)
+	if err != nil {
+		// Pushes from outside the app, use the owner's token
+		token, err = GetAccessTokenForUser(ownerID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get token to publish: %w", err)
+	}
+
+	// Tracked like any publish, the delivery is finished with the job
+	run, err := QueuePublishJob(projectID, "", models.PublishSync)
+	if err != nil {
+		return fmt.Errorf("failed to create publish job: %w", err)
 	}
-	return jsonFiles
+
+	go func() {
+		err := run.Run(func(run *PublishRun) error {
+			return SyncPublishedDocs(projectID, owner, repoName, slug, strings.TrimPrefix(payload.Ref, "refs/heads/"), payload.After, token, run)
+		})
+		finishDelivery(deliveryID, err)
+	}()
+
+	return ErrDeliveryQueued
 }
 
 func getTokenFromName(githubName, projectName string) (string, error) {
@@ -278,39 +334,6 @@ func fetchFileContent(url, token string) (string, error) {
 	return githubResp.Content, nil
 }
 
-func uploadFiles(contents []FileContent, projectName string) {
-	var wg sync.WaitGroup
-
-	bucket := "public-docs"
-
-	// Upload each file in a separate Goroutine
-	for _, file := range contents {
-		wg.Add(1)
-		go func(file FileContent) {
-			defer wg.Done()
-
-			// Convert the content string to a byte slice
-			fileContent := []byte(file.Content)
-			path := projectName + "/" + file.Path
-			// Upload to R2 (Cloudflare S3-compatible storage)
-			_, err := initializer.R2Client.PutObject(context.TODO(), &s3.PutObjectInput{
-				Bucket: &bucket,
-				Key:    &path, // Use the file path as the object key
-				Body:   bytes.NewReader(fileContent),
-			})
-			if err != nil {
-				log.Printf("Failed to upload %s: %v", file.Path, err)
-				return
-			}
-			fmt.Println("Uploaded:", file.Path)
-		}(file)
-	}
-
-	// Wait for all uploads to finish
-	wg.Wait()
-	fmt.Println("All files uploaded successfully!")
-}
-
 type FileContent struct {
 	Path    string `json:"path"`
 	Content string `json:"content"`
@@ -336,12 +359,3 @@ type Links struct {
 	Git  string `json:"git"`
 	HTML string `json:"html"`
 }
-
-func generateGitHubURLs(username, repo string, files []string) []string {
-	var urls []string
-	for _, file := range files {
-		url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", username, repo, file)
-		urls = append(urls, url)
-	}
-	return urls
-}
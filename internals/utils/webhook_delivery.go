@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Akshdhiwar/simpledocs-backend/internals/initializer"
+	"github.com/jackc/pgx/v5"
+)
+
+// VerifyGithubSignature checks the X-Hub-Signature-256 header against the
+// body with GITHUB_WEBHOOK_SECRET. Without a secret nothing is accepted.
+func VerifyGithubSignature(body []byte, signature string) bool {
+	secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+	if secret == "" {
+		fmt.Println("GITHUB_WEBHOOK_SECRET is not set, rejecting webhook")
+		return false
+	}
+
+	got, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	gotMAC, err := hex.DecodeString(got)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(gotMAC, mac.Sum(nil))
+}
+
+// deliveryProject finds the project a delivery is about from its repository
+func deliveryProject(body []byte) *string {
+	var payload struct {
+		Repository struct {
+			Name  string `json:"name"`
+			Owner struct {
+				Login string `json:"login"`
+				Name  string `json:"name"`
+			} `json:"owner"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Repository.Name == "" {
+		return nil
+	}
+
+	owner := payload.Repository.Owner.Login
+	if owner == "" {
+		owner = payload.Repository.Owner.Name
+	}
+
+	var projectID string
+	err := initializer.DB.QueryRow(context.Background(), `
+		SELECT id FROM projects
+		WHERE name = $1 AND LOWER(COALESCE(NULLIF(org, ''), repo_owner)) = LOWER($2)
+	`, payload.Repository.Name, owner).Scan(&projectID)
+	if err != nil {
+		return nil
+	}
+
+	return &projectID
+}
+
+// staleDelivery is how long a delivery can stay processing before it is taken
+// as lost with a crashed instance. It covers a publish job waiting its turn.
+const staleDelivery = 30 * time.Minute
+
+// recordDelivery stores a delivery before it is handled. It returns false
+// for a delivery that was already received, unless it failed last time or
+// went stale so a redelivery from GitHub gets another try.
+func recordDelivery(deliveryID, event string, body []byte) (bool, error) {
+	var id string
+	err := initializer.DB.QueryRow(context.Background(), `
+		INSERT INTO webhook_deliveries (delivery_id, event, project_id, payload, status)
+		VALUES ($1, $2, $3, $4, 'processing')
+		ON CONFLICT (delivery_id) DO UPDATE
+			SET status = 'processing', attempts = webhook_deliveries.attempts + 1, error = '', updated_at = now()
+			WHERE webhook_deliveries.status = 'failed'
+				OR (webhook_deliveries.status = 'processing' AND webhook_deliveries.updated_at < $5)
+		RETURNING delivery_id
+	`, deliveryID, event, deliveryProject(body), body, time.Now().Add(-staleDelivery)).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// finishDelivery saves how handling a delivery went
+func finishDelivery(deliveryID string, handleErr error) {
+	status, message := "succeeded", ""
+	if handleErr != nil {
+		status, message = "failed", handleErr.Error()
+	}
+
+	_, err := initializer.DB.Exec(context.Background(), `
+		UPDATE webhook_deliveries SET status = $2, error = $3, updated_at = now() WHERE delivery_id = $1
+	`, deliveryID, status, message)
+	if err != nil {
+		fmt.Println("Error updating webhook delivery:", err)
+	}
+}
+
+// ReplayDelivery handles a failed or stale delivery of the project again from
+// its stored payload. ErrDeliveryQueued means a publish job finishes it.
+func ReplayDelivery(projectID, deliveryID string) error {
+	var event string
+	var body []byte
+
+	// Claiming it as processing keeps two replays from running at once
+	err := initializer.DB.QueryRow(context.Background(), `
+		UPDATE webhook_deliveries
+		SET status = 'processing', attempts = attempts + 1, error = '', updated_at = now()
+		WHERE delivery_id = $1 AND project_id = $2
+			AND (status = 'failed' OR (status = 'processing' AND updated_at < $3))
+		RETURNING event, payload
+	`, deliveryID, projectID, time.Now().Add(-staleDelivery)).Scan(&event, &body)
+	if err == pgx.ErrNoRows {
+		return ErrDeliveryNotReplayable
+	}
+	if err != nil {
+		return err
+	}
+
+	err = handleGithubEvent(deliveryID, event, body)
+	if err != ErrDeliveryQueued {
+		finishDelivery(deliveryID, err)
+	}
+
+	return err
+}
+
+// ErrDeliveryNotReplayable is returned for deliveries that don't exist or
+// didn't fail
+var ErrDeliveryNotReplayable = errors.New("delivery not found or not failed")
+
+// ErrDeliveryQueued is returned when a delivery was handed to a publish job,
+// the job finishes the delivery once it is done
+var ErrDeliveryQueued = errors.New("delivery queued")
@@ -1,27 +1,230 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// Client represents a single WebSocket connection in a project room
+type Client struct {
+	connID string
+	userID string // Verified by ProjectMemberMiddleware before the upgrade
+	conn   *websocket.Conn
+	send   chan []byte // Outgoing messages, drained by writePump
+	mu     sync.Mutex  // Guards closed so nothing is sent on a closed channel
+	closed bool
+
+	// While missed events are replayed, broadcasts are held back in pending
+	// so they reach the client after the replay
+	replaying bool
+	pending   [][]byte
+}
+
 // Room represents a project room
 type Room struct {
-	clients map[*websocket.Conn]bool // Connected clients
-	mu      sync.Mutex               // Mutex for thread-safe access
+	clients  map[*Client]bool           // Connected clients
+	presence map[*Client]*PresenceEntry // What each connected client is doing
+	remote   map[string]*remotePresence // Presence of clients connected to other instances, keyed by connection id
+	mu       sync.Mutex                 // Mutex for thread-safe access
 }
 
-// Global map to store rooms (keyed by project ID)
+// Global map to store rooms (keyed by project ID). Only clients connected to
+// this instance are in it, messages from other instances arrive through the
+// pub/sub layer in pubsub.go
 var rooms = make(map[string]*Room)
 var roomsMu sync.Mutex // Mutex for the rooms map
 
-// WebSocket upgrader
+// WebSocket upgrader, only the frontends allowed by CORS may connect
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true }, // Allow all origins (for simplicity)
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		// Non browser clients don't send an origin, they still need the auth cookie
+		return origin == "" || IsAllowedOrigin(origin)
+	},
+}
+
+// Size of the outgoing buffer per client, slow clients are dropped when it is full
+const clientSendBuffer = 64
+
+// Time allowed to write a single message to the client
+const writeWait = 10 * time.Second
+
+func newClient(conn *websocket.Conn, userID string) *Client {
+	return &Client{
+		connID: uuid.NewString(),
+		userID: userID,
+		conn:   conn,
+		send:   make(chan []byte, clientSendBuffer),
+
+		replaying: true,
+	}
+}
+
+// close stops the write pump, it is safe to call more than once
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// trySend queues a message without blocking, it returns false when the
+// client is closed or its buffer is full
+func (c *Client) trySend(message []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	if c.replaying {
+		if len(c.pending) >= clientSendBuffer {
+			return false
+		}
+		c.pending = append(c.pending, message)
+		return true
+	}
+
+	return c.queue(message)
+}
+
+// queue must be called with mu held
+func (c *Client) queue(message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// finishReplay releases the broadcasts held back during the replay, it
+// returns false when they don't fit in the buffer
+func (c *Client) finishReplay() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.replaying = false
+
+	for _, message := range c.pending {
+		if c.closed || !c.queue(message) {
+			return false
+		}
+	}
+	c.pending = nil
+
+	return true
+}
+
+// writePump is the only goroutine that writes to the connection, it also
+// pings the client so dead connections are noticed
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The client was removed from the room
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				fmt.Println("Error writing message:", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				fmt.Println("Error sending ping:", err)
+				return
+			}
+		}
+	}
+}
+
+// sendEvent queues a single event for this client only
+func (c *Client) sendEvent(event Event) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		fmt.Println("Error marshaling event:", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || !c.queue(message) {
+		fmt.Println("Client send buffer full, dropping message")
+	}
+}
+
+// replay writes the events the client missed straight to the connection,
+// it must run before writePump starts. Clients send the seq of the last event
+// they applied as ?last_seq=, without it they only learn the current seq.
+// Either way they get a sync message and must ignore events with a seq they
+// already have, since live events can overlap the replay.
+func (c *Client) replay(projectID, lastSeqParam string) error {
+	var messages [][]byte
+	var current int64
+	var resync bool
+	var err error
+
+	if lastSeqParam == "" {
+		current, err = currentEventSeq(projectID)
+	} else {
+		lastSeq, parseErr := strconv.ParseInt(lastSeqParam, 10, 64)
+		if parseErr != nil || lastSeq < 0 {
+			resync = true
+			current, err = currentEventSeq(projectID)
+		} else {
+			messages, current, resync, err = eventsSince(projectID, lastSeq)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return err
+		}
+	}
+
+	eventType := EventSync
+	if resync {
+		eventType = EventResyncRequired
+	}
+
+	message, err := json.Marshal(NewEvent(projectID, "", eventType, SyncData{
+		Seq:      current,
+		Replayed: len(messages),
+	}))
+	if err != nil {
+		return err
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(websocket.TextMessage, message)
 }
 
 // Get or create a room for a project
@@ -31,56 +234,121 @@ func getOrCreateRoom(projectID string) *Room {
 
 	room, exists := rooms[projectID]
 	if !exists {
-		room = &Room{clients: make(map[*websocket.Conn]bool)}
+		room = &Room{
+			clients:  make(map[*Client]bool),
+			presence: make(map[*Client]*PresenceEntry),
+			remote:   make(map[string]*remotePresence),
+		}
 		rooms[projectID] = room
 	}
 	return room
 }
 
+// Get a room for a project if anyone is connected to it
+func getRoom(projectID string) *Room {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+
+	return rooms[projectID]
+}
+
 // Add a client to the room
-func (r *Room) addClient(conn *websocket.Conn) {
+func (r *Room) addClient(client *Client) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.clients[conn] = true
+	r.clients[client] = true
 }
 
-// Remove a client from the room and clean up the room if empty
-func (r *Room) removeClient(conn *websocket.Conn, projectID string) {
+// Remove a client from the room and clean up the room if empty, it returns
+// the presence entry the client had
+func (r *Room) removeClient(client *Client, projectID string) (PresenceEntry, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	delete(r.clients, conn)
+	delete(r.clients, client)
+	client.close()
+
+	entry, hadPresence := r.presence[client]
+	delete(r.presence, client)
+
+	var left PresenceEntry
+	if hadPresence {
+		left = *entry
+	}
 
 	// If the room is empty, remove it from the global rooms map
 	if len(r.clients) == 0 {
 		roomsMu.Lock()
 		defer roomsMu.Unlock()
-		delete(rooms, projectID)
+		if rooms[projectID] == r {
+			delete(rooms, projectID)
+		}
 		fmt.Printf("Room for project %s has been removed (no users connected).\n", projectID)
 	}
+
+	return left, hadPresence
 }
 
-// Broadcast a message to all clients in the room (except the sender)
-func (r *Room) broadcast(message []byte, sender *websocket.Conn) {
+// Broadcast a message to all local clients in the room (except the sender connection)
+func (r *Room) broadcast(message []byte, skipConn string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	for client := range r.clients {
-		if client != sender {
-			err := client.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				fmt.Println("Error broadcasting message:", err)
-				client.Close()
-				delete(r.clients, client)
-			}
+		if skipConn != "" && client.connID == skipConn {
+			continue
+		}
+
+		if !client.trySend(message) {
+			// The client is not keeping up, drop it
+			fmt.Println("Error broadcasting message: client send buffer full")
+			delete(r.clients, client)
+			client.close()
 		}
 	}
 }
 
-// WebSocket handler
+// DisconnectUser closes every connection a user has open in the project room
+// on every instance, used when the user is removed from the project
+func DisconnectUser(projectID, userID string) {
+	publishRealtime(RealtimeMessage{
+		Kind:      realtimeKindDisconnect,
+		ProjectID: projectID,
+		UserID:    userID,
+	})
+}
+
+func disconnectLocalUser(projectID, userID string) {
+	room := getRoom(projectID)
+	if room == nil {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	for client := range room.clients {
+		if client.userID == userID {
+			delete(room.clients, client)
+			client.close()
+		}
+	}
+}
+
+// OnProjectLeave is called when a websocket connection of a user to a
+// project closes, main uses it to commit the saves the user left queued
+var OnProjectLeave func(projectID, userID string)
+
+// WebSocket handler, ProjectMemberMiddleware must run before it
 func HandleWebSocket(c *gin.Context) {
 	// Get project ID from query parameters
 	projectID := c.Param("projectID")
+	userID := c.GetString("userID")
+
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
 
 	// Upgrade HTTP connection to WebSocket
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -88,14 +356,64 @@ func HandleWebSocket(c *gin.Context) {
 		fmt.Println("Error upgrading to WebSocket:", err)
 		return
 	}
-	defer conn.Close()
 
-	// Add the client to the project room
+	client := newClient(conn, userID)
+
+	// Add the client to the project room, broadcasts are held back until the
+	// missed events are replayed
 	room := getOrCreateRoom(projectID)
-	room.addClient(conn)
-	defer room.removeClient(conn, projectID) // Clean up on disconnect
+	room.addClient(client)
+
+	replayErr := client.replay(projectID, c.Query("last_seq"))
+	go client.writePump()
+
+	if replayErr != nil {
+		fmt.Println("Error replaying missed events:", replayErr)
+		room.removeClient(client, projectID)
+		return
+	}
+
+	if !client.finishReplay() {
+		fmt.Println("Client send buffer full after replay, dropping client")
+		room.removeClient(client, projectID)
+		return
+	}
+
+	// Clean up on disconnect and tell the others the user left
+	defer func() {
+		if entry, ok := room.removeClient(client, projectID); ok {
+			publishPresence(projectID, client, NewEvent(projectID, userID, EventPresenceLeave, PresenceLeaveData{
+				ConnID: entry.ConnID,
+				UserID: entry.UserID,
+			}))
+		}
+
+		if OnProjectLeave != nil {
+			go OnProjectLeave(projectID, userID)
+		}
+	}()
 
-	fmt.Printf("User connected to project: %s\n", projectID)
+	fmt.Printf("User %s connected to project: %s\n", userID, projectID)
+
+	// Send the presence snapshot to the new client and the join diff to everyone else
+	entry := newPresenceEntry(client.connID, userID)
+	snapshot := room.joinPresence(client, entry)
+	client.sendEvent(NewEvent(projectID, "", EventPresenceSnapshot, PresenceSnapshotData{
+		ConnID:  client.connID,
+		Entries: snapshot,
+	}))
+	publishPresence(projectID, client, NewEvent(projectID, userID, EventPresenceJoin, entry))
+
+	// Ask the other instances for their presence so the next snapshot is complete
+	publishRealtime(RealtimeMessage{Kind: realtimeKindPresenceRefill, ProjectID: projectID})
+
+	// Every pong or message keeps the connection and its presence alive
+	conn.SetReadDeadline(time.Now().Add(presenceTTL))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(presenceTTL))
+		room.touchPresence(client)
+		return nil
+	})
 
 	// Listen for messages from the client
 	for {
@@ -105,9 +423,43 @@ func HandleWebSocket(c *gin.Context) {
 			break
 		}
 
-		fmt.Printf("Message from project %s: %s\n", projectID, message)
+		conn.SetReadDeadline(time.Now().Add(presenceTTL))
+		room.touchPresence(client)
+
+		handleClientMessage(room, client, projectID, message)
+	}
+}
 
-		// Broadcast the message to all clients in the room
-		room.broadcast(message, conn)
+// handleClientMessage decodes a message sent by the client and answers it,
+// project events are only ever originated by the server
+func handleClientMessage(room *Room, client *Client, projectID string, message []byte) {
+	var msg ClientMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		client.sendEvent(NewEvent(projectID, "", EventError, ErrorData{Message: "invalid message"}))
+		return
+	}
+
+	if msg.Version != EventProtocolVersion {
+		client.sendEvent(NewEvent(projectID, "", EventError, ErrorData{Message: "unsupported protocol version"}))
+		return
+	}
+
+	switch msg.Type {
+	case EventPing:
+		client.sendEvent(NewEvent(projectID, "", EventPong, nil))
+	case EventHeartbeat:
+		// Nothing to answer, the read loop already refreshed the presence entry
+	case EventPresenceUpdate:
+		var update PresenceUpdateData
+		if err := json.Unmarshal(msg.Data, &update); err != nil {
+			client.sendEvent(NewEvent(projectID, "", EventError, ErrorData{Message: "invalid presence update"}))
+			return
+		}
+
+		if entry, changed := room.movePresence(client, update); changed {
+			publishPresence(projectID, client, NewEvent(projectID, client.userID, EventPresenceMove, entry))
+		}
+	default:
+		client.sendEvent(NewEvent(projectID, "", EventError, ErrorData{Message: "unknown message type " + string(msg.Type)}))
 	}
 }